@@ -3,13 +3,26 @@ package ast
 import (
 	"bytes"
 	"interpreter/token"
+	"slices"
+	"strconv"
 	"strings"
 )
 
 // Node general node interface
 type Node interface {
 	TokenLiteral() string
+	// Pos and End are the byte offsets of the node's first character and of
+	// the one just past its last, so the source of a node is src[Pos():End()].
+	// The tokens a node holds also carry line and column numbers.
+	Pos() int
+	End() int
 	String() string
+	// write renders the node into out. String and Write are built on it so a
+	// whole tree is rendered into one buffer instead of one per node. Being
+	// unexported, it also closes the interface on purpose: only this
+	// package's types are Nodes, which Equal, Format, Rewrite and ToDot
+	// rely on when they switch over every node type.
+	write(out *bytes.Buffer)
 }
 
 // Statement Statement Node interface
@@ -27,6 +40,9 @@ type Expression interface {
 // Program node is going to be the root of each AST
 type Program struct {
 	Statements []Statement
+	// Comments holds the program's comments in source order. The parser
+	// only fills it in when asked to, see parser.WithCommentPreservation.
+	Comments []token.Token
 }
 
 // TokenLiteral method on Program to satistfy Node interface.
@@ -38,14 +54,58 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
-// String only creates a buffer and writes the return values of each statement's String() method on it. Then returns the buffer as a string.
+// Pos and End span the program's statements; both are 0 for an empty one.
+func (p *Program) Pos() int {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return 0
+}
+func (p *Program) End() int {
+	if n := len(p.Statements); n > 0 {
+		return p.Statements[n-1].End()
+	}
+	return 0
+}
+
+// String only creates a buffer and writes each statement on it. Then returns the buffer as a string.
 //   - note. most of the work is delegate to the program Statements.
-func (p *Program) String() string {
-	var out bytes.Buffer
+func (p *Program) String() string { return render(p) }
+func (p *Program) write(out *bytes.Buffer) {
 	for _, s := range p.Statements {
-		out.WriteString(s.String())
+		s.write(out)
 	}
-	return out.String()
+}
+
+// Span returns the line and column positions of node's first character and
+// of the one just past its last. src must be the input node was parsed from;
+// Pos and End give the same range as byte offsets.
+func Span(src string, node Node) (start, end token.Position) {
+	return position(src, node.Pos()), position(src, node.End())
+}
+
+func position(src string, offset int) token.Position {
+	offset = min(max(offset, 0), len(src))
+	line := strings.Count(src[:offset], "\n") + 1
+	column := offset - strings.LastIndexByte(src[:offset], '\n')
+	return token.Position{Offset: offset, Line: line, Column: column}
+}
+
+// Trivia holds the comments around a statement, for tools that print source
+// back out. Statements embed it; the parser only fills it in when asked to,
+// see parser.WithCommentPreservation.
+type Trivia struct {
+	Leading  []token.Token // comments between the previous token and the statement
+	Trailing []token.Token // comments after the statement on its last line
+}
+
+// Comments returns the trivia, giving access to it through an interface.
+func (t *Trivia) Comments() *Trivia { return t }
+
+// Commented is implemented by the nodes that embed Trivia.
+type Commented interface {
+	Node
+	Comments() *Trivia
 }
 
 // Integer Literals
@@ -54,50 +114,78 @@ type IntegerLiteral struct {
 	Value int64
 }
 
-func (il *IntegerLiteral) expressionNode()      {}
-func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
-func (il *IntegerLiteral) String() string       { return il.Token.Literal }
+func (il *IntegerLiteral) expressionNode()         {}
+func (il *IntegerLiteral) TokenLiteral() string    { return il.Token.Literal }
+func (il *IntegerLiteral) String() string          { return il.Token.Literal }
+func (il *IntegerLiteral) write(out *bytes.Buffer) { out.WriteString(il.Token.Literal) }
+func (il *IntegerLiteral) Pos() int                { return il.Token.Offset }
+func (il *IntegerLiteral) End() int                { return il.Token.End }
 
 // LET
 type LetStatement struct {
 	Token token.Token // LET token
 	Name  *Identifier
+	Names []*Identifier // every name of let x, y = ...; Name is Names[0]
 	Value Expression
+	Trivia
 }
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
-func (ls *LetStatement) String() string {
-	var out bytes.Buffer
+func (ls *LetStatement) String() string       { return render(ls) }
+func (ls *LetStatement) write(out *bytes.Buffer) {
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Name.String())
+	if len(ls.Names) > 1 {
+		for i, name := range ls.Names {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			name.write(out)
+		}
+	} else {
+		ls.Name.write(out)
+	}
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
-		out.WriteString(ls.Value.String())
+		ls.Value.write(out)
 	}
 	out.WriteString(";")
-	return out.String()
+}
+
+func (ls *LetStatement) Pos() int { return ls.Token.Offset }
+func (ls *LetStatement) End() int {
+	if ls.Value != nil {
+		return ls.Value.End()
+	}
+	return ls.Name.End()
 }
 
 // RETURN
 type ReturnStatement struct {
 	Token       token.Token
 	ReturnValue Expression
+	Trivia
 }
 
 func (rs *ReturnStatement) statementNode()       {} // empty, just to satisfy interface
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
-func (rs *ReturnStatement) String() string {
-	var out bytes.Buffer
-
+func (rs *ReturnStatement) String() string       { return render(rs) }
+func (rs *ReturnStatement) write(out *bytes.Buffer) {
 	out.WriteString(rs.TokenLiteral() + " ")
 
 	if rs.ReturnValue != nil {
-		out.WriteString(rs.ReturnValue.String())
+		rs.ReturnValue.write(out)
 	}
 	out.WriteString(";")
-	return out.String()
+}
+
+func (rs *ReturnStatement) Pos() int { return rs.Token.Offset }
+func (rs *ReturnStatement) End() int {
+	if rs.ReturnValue != nil {
+		return rs.ReturnValue.End()
+	}
+	return rs.Token.End
 }
 
 // IDENT
@@ -111,6 +199,9 @@ func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 func (i *Identifier) String() string {
 	return i.Value
 }
+func (i *Identifier) write(out *bytes.Buffer) { out.WriteString(i.Value) }
+func (i *Identifier) Pos() int                { return i.Token.Offset }
+func (i *Identifier) End() int                { return i.Token.End }
 
 // PrefixExpression
 type PrefixExpression struct {
@@ -124,16 +215,17 @@ type PrefixExpression struct {
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
 
-func (pe *PrefixExpression) String() string {
-	var out bytes.Buffer
-
+func (pe *PrefixExpression) String() string { return render(pe) }
+func (pe *PrefixExpression) write(out *bytes.Buffer) {
 	out.WriteString("(")
 	out.WriteString(pe.Operator)
-	out.WriteString(pe.Right.String())
+	pe.Right.write(out)
 	out.WriteString(")")
-	return out.String()
 }
 
+func (pe *PrefixExpression) Pos() int { return pe.Token.Offset }
+func (pe *PrefixExpression) End() int { return pe.Right.End() }
+
 type InfixExpression struct {
 	Token    token.Token // operator token e.g. +
 	Left     Expression  // Left side of expression
@@ -143,18 +235,18 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
-func (ie *InfixExpression) String() string {
-
-	var out bytes.Buffer
+func (ie *InfixExpression) String() string       { return render(ie) }
+func (ie *InfixExpression) write(out *bytes.Buffer) {
 	out.WriteString("(")
-	out.WriteString(ie.Left.String())
+	ie.Left.write(out)
 	out.WriteString(" " + ie.Operator + " ")
-	out.WriteString(ie.Right.String())
+	ie.Right.write(out)
 	out.WriteString(")")
-	return out.String()
-
 }
 
+func (ie *InfixExpression) Pos() int { return ie.Left.Pos() }
+func (ie *InfixExpression) End() int { return ie.Right.End() }
+
 // ExpressionStatement
 //
 //	-- token.Token which every node has
@@ -162,15 +254,29 @@ func (ie *InfixExpression) String() string {
 type ExpressionStatement struct {
 	Token      token.Token // first expression in a statement
 	Expression Expression
+	Trivia
 }
 
 func (es *ExpressionStatement) statementNode()       {} // assign node to statement
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
-func (es *ExpressionStatement) String() string {
+func (es *ExpressionStatement) String() string       { return render(es) }
+func (es *ExpressionStatement) write(out *bytes.Buffer) {
 	if es.Expression != nil {
-		return es.Expression.String()
+		es.Expression.write(out)
+	}
+}
+
+func (es *ExpressionStatement) Pos() int {
+	if es.Expression == nil {
+		return es.Token.Offset
 	}
-	return ""
+	return es.Expression.Pos()
+}
+func (es *ExpressionStatement) End() int {
+	if es.Expression == nil {
+		return es.Token.End
+	}
+	return es.Expression.End()
 }
 
 // BOOLS
@@ -180,9 +286,24 @@ type Boolean struct {
 	Value bool
 }
 
-func (b *Boolean) expressionNode()      {}
-func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
-func (b *Boolean) String() string       { return b.Token.Literal }
+func (b *Boolean) expressionNode()         {}
+func (b *Boolean) TokenLiteral() string    { return b.Token.Literal }
+func (b *Boolean) String() string          { return b.Token.Literal }
+func (b *Boolean) write(out *bytes.Buffer) { out.WriteString(b.Token.Literal) }
+func (b *Boolean) Pos() int                { return b.Token.Offset }
+func (b *Boolean) End() int                { return b.Token.End }
+
+// NullLiteral is an explicit null, the absence of a value.
+type NullLiteral struct {
+	Token token.Token
+}
+
+func (n *NullLiteral) expressionNode()         {}
+func (n *NullLiteral) TokenLiteral() string    { return n.Token.Literal }
+func (n *NullLiteral) String() string          { return n.Token.Literal }
+func (n *NullLiteral) write(out *bytes.Buffer) { out.WriteString(n.Token.Literal) }
+func (n *NullLiteral) Pos() int                { return n.Token.Offset }
+func (n *NullLiteral) End() int                { return n.Token.End }
 
 // IF LOGIC
 
@@ -193,17 +314,27 @@ func (b *Boolean) String() string       { return b.Token.Literal }
 type BlockStatement struct {
 	Token      token.Token
 	Statements []Statement
+	Rbrace     token.Token // the closing }
 }
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
-func (bs *BlockStatement) String() string {
-	var out bytes.Buffer
-
+func (bs *BlockStatement) String() string       { return render(bs) }
+func (bs *BlockStatement) write(out *bytes.Buffer) {
 	for _, s := range bs.Statements {
-		out.WriteString(s.String())
+		s.write(out)
+	}
+}
+
+func (bs *BlockStatement) Pos() int { return bs.Token.Offset }
+func (bs *BlockStatement) End() int {
+	if bs.Rbrace.Type == token.RBRACE {
+		return bs.Rbrace.End
+	}
+	if n := len(bs.Statements); n > 0 { // an else if, or unterminated
+		return bs.Statements[n-1].End()
 	}
-	return out.String()
+	return bs.Token.End
 }
 
 // Now we define the If Expression
@@ -217,67 +348,845 @@ type IfExpression struct {
 
 func (is *IfExpression) expressionNode()      {}
 func (is *IfExpression) TokenLiteral() string { return is.Token.Literal }
-func (is *IfExpression) String() string {
-	var out bytes.Buffer
-
+func (is *IfExpression) String() string       { return render(is) }
+func (is *IfExpression) write(out *bytes.Buffer) {
 	out.WriteString("if")
-	out.WriteString(is.Condition.String())
+	is.Condition.write(out)
 	out.WriteString(" ")
-	out.WriteString(is.Consequence.String())
+	is.Consequence.write(out)
 
 	if is.Alternative != nil {
 		out.WriteString("else")
-		out.WriteString(is.Alternative.String())
+		is.Alternative.write(out)
 	}
-	return out.String()
+}
 
+func (is *IfExpression) Pos() int { return is.Token.Offset }
+func (is *IfExpression) End() int {
+	if is.Alternative != nil {
+		return is.Alternative.End()
+	}
+	return is.Consequence.End()
 }
 
 type FunctionLiteral struct {
 	Token      token.Token
 	Parameters []*Identifier
+	Variadic   bool // the last parameter, written rest..., collects any extra arguments
+	Generator  bool // the body contains yield, outside any nested function
 	Body       *BlockStatement
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
-func (fl *FunctionLiteral) String() string {
-	var out bytes.Buffer
+func (fl *FunctionLiteral) String() string       { return render(fl) }
+func (fl *FunctionLiteral) write(out *bytes.Buffer) {
+	out.WriteString(fl.TokenLiteral())
+	fl.writeParameters(out)
+	fl.Body.write(out)
+}
 
-	params := []string{}
+func (fl *FunctionLiteral) Pos() int { return fl.Token.Offset }
+func (fl *FunctionLiteral) End() int { return fl.Body.End() }
 
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
-	}
-
-	out.WriteString(fl.TokenLiteral())
+// writeParameters writes the parenthesized parameter list.
+func (fl *FunctionLiteral) writeParameters(out *bytes.Buffer) {
 	out.WriteString("(")
-	out.WriteString(strings.Join(params, ","))
+	for i, p := range fl.Parameters {
+		if i > 0 {
+			out.WriteString(",")
+		}
+		p.write(out)
+	}
+	if fl.Variadic {
+		out.WriteString("...")
+	}
 	out.WriteString(")")
-	out.WriteString(fl.Body.String())
-	return out.String()
+}
 
+// AssertStatement fails at run time when Condition does not hold, reporting
+// Message if one is given.
+type AssertStatement struct {
+	Token     token.Token // the ASSERT token
+	Condition Expression
+	Message   Expression // nil unless given
+	Trivia
+}
+
+func (as *AssertStatement) statementNode()       {}
+func (as *AssertStatement) TokenLiteral() string { return as.Token.Literal }
+func (as *AssertStatement) String() string       { return render(as) }
+func (as *AssertStatement) write(out *bytes.Buffer) {
+	out.WriteString(as.TokenLiteral() + " ")
+	as.Condition.write(out)
+	if as.Message != nil {
+		out.WriteString(", ")
+		as.Message.write(out)
+	}
+	out.WriteString(";")
 }
 
+func (as *AssertStatement) Pos() int { return as.Token.Offset }
+func (as *AssertStatement) End() int {
+	if as.Message != nil {
+		return as.Message.End()
+	}
+	return as.Condition.End()
+}
+
+// YieldStatement hands Value to the caller of a generator, see
+// FunctionLiteral.Generator.
+type YieldStatement struct {
+	Token token.Token // the YIELD token
+	Value Expression
+	Trivia
+}
+
+func (ys *YieldStatement) statementNode()       {}
+func (ys *YieldStatement) TokenLiteral() string { return ys.Token.Literal }
+func (ys *YieldStatement) String() string       { return render(ys) }
+func (ys *YieldStatement) write(out *bytes.Buffer) {
+	out.WriteString(ys.TokenLiteral() + " ")
+	if ys.Value != nil {
+		ys.Value.write(out)
+	}
+	out.WriteString(";")
+}
+
+func (ys *YieldStatement) Pos() int { return ys.Token.Offset }
+func (ys *YieldStatement) End() int {
+	if ys.Value != nil {
+		return ys.Value.End()
+	}
+	return ys.Token.End
+}
+
+// FunctionStatement is a named function declaration, fn add(a, b) { a + b },
+// which binds Name to Function like let add = fn(a, b) { a + b }; does.
+type FunctionStatement struct {
+	Token    token.Token // the FUNCTION token
+	Name     *Identifier
+	Function *FunctionLiteral
+	Trivia
+}
+
+func (fs *FunctionStatement) statementNode()       {}
+func (fs *FunctionStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *FunctionStatement) String() string       { return render(fs) }
+func (fs *FunctionStatement) write(out *bytes.Buffer) {
+	out.WriteString(fs.TokenLiteral() + " ")
+	fs.Name.write(out)
+	fs.Function.writeParameters(out)
+	fs.Function.Body.write(out)
+}
+
+func (fs *FunctionStatement) Pos() int { return fs.Token.Offset }
+func (fs *FunctionStatement) End() int { return fs.Function.End() }
+
 type CallExpression struct {
 	Token     token.Token
 	Function  Expression
 	Arguments []Expression
+	Rparen    token.Token // the closing )
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
-func (ce *CallExpression) String() string {
-	var out bytes.Buffer
+func (ce *CallExpression) String() string       { return render(ce) }
+func (ce *CallExpression) write(out *bytes.Buffer) {
+	ce.Function.write(out)
+	out.WriteString("(")
+	for i, a := range ce.Arguments {
+		if i > 0 {
+			out.WriteString(",")
+		}
+		a.write(out)
+	}
+	out.WriteString(")")
+}
 
-	args := []string{}
-	for _, a := range ce.Arguments {
-		args = append(args, a.String())
+func (ce *CallExpression) Pos() int { return ce.Function.Pos() }
+func (ce *CallExpression) End() int { return ce.Rparen.End }
+
+// TupleLiteral is a fixed list of values, written (1, "a", true), or without
+// parentheses as the a, b of return a, b.
+type TupleLiteral struct {
+	Token    token.Token // the ( token, or the first , when unparenthesized
+	Elements []Expression
+	Rparen   token.Token // the closing ), when parenthesized
+}
+
+func (tl *TupleLiteral) expressionNode()      {}
+func (tl *TupleLiteral) TokenLiteral() string { return tl.Token.Literal }
+func (tl *TupleLiteral) String() string       { return render(tl) }
+func (tl *TupleLiteral) write(out *bytes.Buffer) {
+	out.WriteString("(")
+	for i, e := range tl.Elements {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		e.write(out)
 	}
+	if len(tl.Elements) == 1 {
+		out.WriteString(",") // (x) would read back as a grouped x
+	}
+	out.WriteString(")")
+}
 
-	out.WriteString(ce.Function.String())
+func (tl *TupleLiteral) Pos() int {
+	if tl.Token.Type == token.LPAREN {
+		return tl.Token.Offset
+	}
+	return tl.Elements[0].Pos()
+}
+func (tl *TupleLiteral) End() int {
+	if tl.Token.Type == token.LPAREN {
+		return tl.Rparen.End
+	}
+	return tl.Elements[len(tl.Elements)-1].End()
+}
+
+// TupleIndexExpression is element access on a tuple, such as pair.0.
+type TupleIndexExpression struct {
+	Token token.Token // the . token
+	Tuple Expression
+	Index *IntegerLiteral
+}
+
+func (ti *TupleIndexExpression) expressionNode()      {}
+func (ti *TupleIndexExpression) TokenLiteral() string { return ti.Token.Literal }
+func (ti *TupleIndexExpression) String() string       { return render(ti) }
+func (ti *TupleIndexExpression) write(out *bytes.Buffer) {
+	ti.Tuple.write(out)
+	out.WriteString(".")
+	ti.Index.write(out)
+}
+
+func (ti *TupleIndexExpression) Pos() int { return ti.Tuple.Pos() }
+func (ti *TupleIndexExpression) End() int { return ti.Index.End() }
+
+// PipeExpression is value |> function. The evaluator calls Function with
+// Value; when Function is itself a call, as in xs |> map(f), Value is passed
+// as its first argument.
+type PipeExpression struct {
+	Token    token.Token // the |> token
+	Value    Expression
+	Function Expression
+}
+
+func (pe *PipeExpression) expressionNode()      {}
+func (pe *PipeExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PipeExpression) String() string       { return render(pe) }
+func (pe *PipeExpression) write(out *bytes.Buffer) {
 	out.WriteString("(")
-	out.WriteString(strings.Join(args, ","))
+	pe.Value.write(out)
+	out.WriteString(" |> ")
+	pe.Function.write(out)
 	out.WriteString(")")
-	return out.String()
 }
+
+func (pe *PipeExpression) Pos() int { return pe.Value.Pos() }
+func (pe *PipeExpression) End() int { return pe.Function.End() }
+
+// MemberExpression is a property access such as point.x.
+type MemberExpression struct {
+	Token    token.Token // the . token
+	Object   Expression
+	Property *Identifier
+}
+
+func (me *MemberExpression) expressionNode()      {}
+func (me *MemberExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MemberExpression) String() string       { return render(me) }
+func (me *MemberExpression) write(out *bytes.Buffer) {
+	me.Object.write(out)
+	out.WriteString(".")
+	me.Property.write(out)
+}
+
+func (me *MemberExpression) Pos() int { return me.Object.Pos() }
+func (me *MemberExpression) End() int { return me.Property.End() }
+
+// IndexExpression is Left[Index].
+type IndexExpression struct {
+	Token    token.Token // the [ token
+	Left     Expression
+	Index    Expression
+	Rbracket token.Token // the closing ]
+}
+
+func (ie *IndexExpression) expressionNode()      {}
+func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) String() string       { return render(ie) }
+func (ie *IndexExpression) write(out *bytes.Buffer) {
+	out.WriteString("(")
+	ie.Left.write(out)
+	out.WriteString("[")
+	ie.Index.write(out)
+	out.WriteString("])")
+}
+
+func (ie *IndexExpression) Pos() int { return ie.Left.Pos() }
+func (ie *IndexExpression) End() int { return ie.Rbracket.End }
+
+// SliceExpression is Left[Low:High]. Low and High are nil when left out, as
+// in xs[:2] and xs[2:].
+type SliceExpression struct {
+	Token    token.Token // the [ token
+	Left     Expression
+	Low      Expression
+	High     Expression
+	Rbracket token.Token // the closing ]
+}
+
+func (se *SliceExpression) expressionNode()      {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) String() string       { return render(se) }
+func (se *SliceExpression) write(out *bytes.Buffer) {
+	out.WriteString("(")
+	se.Left.write(out)
+	out.WriteString("[")
+	if se.Low != nil {
+		se.Low.write(out)
+	}
+	out.WriteString(":")
+	if se.High != nil {
+		se.High.write(out)
+	}
+	out.WriteString("])")
+}
+
+func (se *SliceExpression) Pos() int { return se.Left.Pos() }
+func (se *SliceExpression) End() int { return se.Rbracket.End }
+
+// NamedArgument is a call argument passed by name, as in draw(x: 1). It
+// appears in CallExpression.Arguments alongside positional arguments.
+type NamedArgument struct {
+	Token token.Token // the name's IDENT token
+	Name  *Identifier
+	Value Expression
+}
+
+func (na *NamedArgument) expressionNode()      {}
+func (na *NamedArgument) TokenLiteral() string { return na.Token.Literal }
+func (na *NamedArgument) String() string       { return render(na) }
+func (na *NamedArgument) write(out *bytes.Buffer) {
+	na.Name.write(out)
+	out.WriteString(":")
+	na.Value.write(out)
+}
+
+func (na *NamedArgument) Pos() int { return na.Name.Pos() }
+func (na *NamedArgument) End() int { return na.Value.End() }
+
+// STRINGS
+
+type StringLiteral struct {
+	Token token.Token
+	Value string
+}
+
+func (sl *StringLiteral) expressionNode()         {}
+func (sl *StringLiteral) TokenLiteral() string    { return sl.Token.Literal }
+func (sl *StringLiteral) String() string          { return sl.Token.Literal }
+func (sl *StringLiteral) write(out *bytes.Buffer) { out.WriteString(sl.Token.Literal) }
+func (sl *StringLiteral) Pos() int                { return sl.Token.Offset }
+func (sl *StringLiteral) End() int                { return sl.Token.End }
+
+// RegexLiteral is a regular expression literal such as /ab+c/i.
+type RegexLiteral struct {
+	Token   token.Token // the REGEX token, /Pattern/Flags as written
+	Pattern string
+	Flags   string
+}
+
+func (rl *RegexLiteral) expressionNode()         {}
+func (rl *RegexLiteral) TokenLiteral() string    { return rl.Token.Literal }
+func (rl *RegexLiteral) String() string          { return rl.Token.Literal }
+func (rl *RegexLiteral) write(out *bytes.Buffer) { out.WriteString(rl.Token.Literal) }
+func (rl *RegexLiteral) Pos() int                { return rl.Token.Offset }
+func (rl *RegexLiteral) End() int                { return rl.Token.End }
+
+// InterpolatedString is a string such as "hi ${name}!". Parts alternates
+// between *StringLiteral text and the interpolated expressions, in source
+// order; empty text between them is left out.
+type InterpolatedString struct {
+	Token token.Token // the STRING_HEAD token
+	Parts []Expression
+	Tail  token.Token // the STRING_TAIL token
+}
+
+func (is *InterpolatedString) expressionNode()      {}
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+func (is *InterpolatedString) String() string       { return render(is) }
+func (is *InterpolatedString) write(out *bytes.Buffer) {
+	for _, part := range is.Parts {
+		if text, ok := part.(*StringLiteral); ok {
+			text.write(out)
+			continue
+		}
+		out.WriteString("${")
+		part.write(out)
+		out.WriteString("}")
+	}
+}
+
+func (is *InterpolatedString) Pos() int { return is.Token.Offset }
+func (is *InterpolatedString) End() int { return is.Tail.End }
+
+// HashLiteral holds its pairs in source order.
+type HashLiteral struct {
+	Token  token.Token // the { token
+	Pairs  []HashPair
+	Rbrace token.Token // the closing }
+}
+
+// HashPair is a single key: value entry of a HashLiteral.
+type HashPair struct {
+	Key   Expression
+	Value Expression
+}
+
+func (hl *HashLiteral) expressionNode()      {}
+func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) String() string       { return render(hl) }
+func (hl *HashLiteral) write(out *bytes.Buffer) {
+	out.WriteString("{")
+	for i, pair := range hl.Pairs {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		pair.Key.write(out)
+		out.WriteString(":")
+		pair.Value.write(out)
+	}
+	out.WriteString("}")
+}
+
+func (hl *HashLiteral) Pos() int { return hl.Token.Offset }
+func (hl *HashLiteral) End() int { return hl.Rbrace.End }
+
+// CLASSES
+
+// ClassStatement declares a class and its methods, class Foo { fn get() {...} }.
+type ClassStatement struct {
+	Token     token.Token // the CLASS token
+	Name      *Identifier
+	Methods   []*FunctionStatement
+	Operators []*OperatorMethod // operator definitions; Members interleaves them with Methods
+	Rbrace    token.Token       // the closing }
+	Trivia
+}
+
+func (cs *ClassStatement) statementNode()       {}
+func (cs *ClassStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ClassStatement) String() string       { return render(cs) }
+func (cs *ClassStatement) write(out *bytes.Buffer) {
+	out.WriteString(cs.TokenLiteral() + " ")
+	cs.Name.write(out)
+	out.WriteString(" {")
+	members := cs.Members()
+	for _, m := range members {
+		out.WriteString(" ")
+		m.write(out)
+	}
+	if len(members) > 0 {
+		out.WriteString(" ")
+	}
+	out.WriteString("}")
+}
+
+// Members returns the methods and operator methods together in source order.
+// Members built without tokens keep methods ahead of operators.
+func (cs *ClassStatement) Members() []Statement {
+	members := make([]Statement, 0, len(cs.Methods)+len(cs.Operators))
+	for _, m := range cs.Methods {
+		members = append(members, m)
+	}
+	for _, op := range cs.Operators {
+		members = append(members, op)
+	}
+	slices.SortStableFunc(members, func(a, b Statement) int { return a.Pos() - b.Pos() })
+	return members
+}
+
+func (cs *ClassStatement) Pos() int { return cs.Token.Offset }
+func (cs *ClassStatement) End() int { return cs.Rbrace.End }
+
+// OperatorMethod defines how an operator applies to instances of a class,
+// fn operator +(other) { ... }. Operator is the operator as written.
+type OperatorMethod struct {
+	Token    token.Token // the FUNCTION token
+	Operator string
+	Function *FunctionLiteral
+}
+
+func (om *OperatorMethod) statementNode()       {}
+func (om *OperatorMethod) TokenLiteral() string { return om.Token.Literal }
+func (om *OperatorMethod) String() string       { return render(om) }
+func (om *OperatorMethod) write(out *bytes.Buffer) {
+	out.WriteString(om.TokenLiteral() + " operator " + om.Operator)
+	om.Function.writeParameters(out)
+	om.Function.Body.write(out)
+}
+
+func (om *OperatorMethod) Pos() int { return om.Token.Offset }
+func (om *OperatorMethod) End() int { return om.Function.End() }
+
+// STRUCTS
+
+// StructStatement declares a named record type, struct Point { x, y }.
+type StructStatement struct {
+	Token  token.Token // the STRUCT token
+	Name   *Identifier
+	Fields []*Identifier
+	Rbrace token.Token // the closing }
+	Trivia
+}
+
+func (ss *StructStatement) statementNode()       {}
+func (ss *StructStatement) TokenLiteral() string { return ss.Token.Literal }
+func (ss *StructStatement) String() string       { return render(ss) }
+func (ss *StructStatement) write(out *bytes.Buffer) {
+	out.WriteString(ss.TokenLiteral() + " ")
+	ss.Name.write(out)
+	out.WriteString(" {")
+	for i, f := range ss.Fields {
+		if i > 0 {
+			out.WriteString(",")
+		}
+		out.WriteString(" ")
+		f.write(out)
+	}
+	if len(ss.Fields) > 0 {
+		out.WriteString(" ")
+	}
+	out.WriteString("}")
+}
+
+func (ss *StructStatement) Pos() int { return ss.Token.Offset }
+func (ss *StructStatement) End() int { return ss.Rbrace.End }
+
+// StructLiteral constructs a struct value, Point{x: 1, y: 2}. Fields are in
+// source order and need not cover every declared field.
+type StructLiteral struct {
+	Token  token.Token // the { token
+	Type   *Identifier
+	Fields []StructField
+	Rbrace token.Token // the closing }
+}
+
+// StructField is a single name: value entry of a StructLiteral.
+type StructField struct {
+	Name  *Identifier
+	Value Expression
+}
+
+func (sl *StructLiteral) expressionNode()      {}
+func (sl *StructLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StructLiteral) String() string       { return render(sl) }
+func (sl *StructLiteral) write(out *bytes.Buffer) {
+	sl.Type.write(out)
+	out.WriteString("{")
+	for i, f := range sl.Fields {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		f.Name.write(out)
+		out.WriteString(":")
+		f.Value.write(out)
+	}
+	out.WriteString("}")
+}
+
+func (sl *StructLiteral) Pos() int { return sl.Type.Pos() }
+func (sl *StructLiteral) End() int { return sl.Rbrace.End }
+
+// LOOPS
+
+// WhileStatement runs Body for as long as Condition holds.
+type WhileStatement struct {
+	Token     token.Token // the WHILE token
+	Condition Expression
+	Body      *BlockStatement
+	Trivia
+}
+
+func (ws *WhileStatement) statementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) String() string       { return render(ws) }
+func (ws *WhileStatement) write(out *bytes.Buffer) {
+	out.WriteString("while")
+	ws.Condition.write(out)
+	out.WriteString(" ")
+	ws.Body.write(out)
+}
+
+func (ws *WhileStatement) Pos() int { return ws.Token.Offset }
+func (ws *WhileStatement) End() int { return ws.Body.End() }
+
+// DoWhileStatement runs Body once and then again for as long as Condition
+// holds.
+type DoWhileStatement struct {
+	Token     token.Token // the DO token
+	Body      *BlockStatement
+	Condition Expression
+	Rparen    token.Token // the ) closing Condition
+	Trivia
+}
+
+func (ds *DoWhileStatement) statementNode()       {}
+func (ds *DoWhileStatement) TokenLiteral() string { return ds.Token.Literal }
+func (ds *DoWhileStatement) String() string       { return render(ds) }
+func (ds *DoWhileStatement) write(out *bytes.Buffer) {
+	out.WriteString("do ")
+	ds.Body.write(out)
+	out.WriteString(" while")
+	ds.Condition.write(out)
+	out.WriteString(";")
+}
+
+func (ds *DoWhileStatement) Pos() int { return ds.Token.Offset }
+func (ds *DoWhileStatement) End() int { return ds.Rparen.End }
+
+// ForStatement is a C-style for (Init; Condition; Post) { Body } loop. Each
+// of Init, Condition and Post may be nil.
+type ForStatement struct {
+	Token     token.Token // the FOR token
+	Init      Statement
+	Condition Expression
+	Post      Statement
+	Body      *BlockStatement
+	Trivia
+}
+
+func (fs *ForStatement) statementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) String() string       { return render(fs) }
+func (fs *ForStatement) write(out *bytes.Buffer) {
+	out.WriteString("for (")
+	writeClause(out, fs.Init)
+	out.WriteString("; ")
+	if fs.Condition != nil {
+		fs.Condition.write(out)
+	}
+	out.WriteString("; ")
+	writeClause(out, fs.Post)
+	out.WriteString(") ")
+	fs.Body.write(out)
+}
+
+func (fs *ForStatement) Pos() int { return fs.Token.Offset }
+func (fs *ForStatement) End() int { return fs.Body.End() }
+
+// writeClause writes a for loop clause without the ";" a let statement
+// renders, since the loop header supplies its own separators.
+func writeClause(out *bytes.Buffer, s Statement) {
+	if s == nil {
+		return
+	}
+	s.write(out)
+	if b := out.Bytes(); len(b) > 0 && b[len(b)-1] == ';' {
+		out.Truncate(out.Len() - 1)
+	}
+}
+
+// ForInStatement runs Body once per element of Iterable, bound to Binding.
+type ForInStatement struct {
+	Token    token.Token // the FOR token
+	Binding  *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+	Trivia
+}
+
+func (fs *ForInStatement) statementNode()       {}
+func (fs *ForInStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForInStatement) String() string       { return render(fs) }
+func (fs *ForInStatement) write(out *bytes.Buffer) {
+	out.WriteString("for (")
+	fs.Binding.write(out)
+	out.WriteString(" in ")
+	fs.Iterable.write(out)
+	out.WriteString(") ")
+	fs.Body.write(out)
+}
+
+func (fs *ForInStatement) Pos() int { return fs.Token.Offset }
+func (fs *ForInStatement) End() int { return fs.Body.End() }
+
+// CompoundAssign is target op= value, e.g. x += 1.
+type CompoundAssign struct {
+	Token    token.Token // the operator token, e.g. +=
+	Target   Expression
+	Operator string
+	Value    Expression
+}
+
+func (ca *CompoundAssign) expressionNode()      {}
+func (ca *CompoundAssign) TokenLiteral() string { return ca.Token.Literal }
+func (ca *CompoundAssign) String() string       { return render(ca) }
+func (ca *CompoundAssign) write(out *bytes.Buffer) {
+	out.WriteString("(")
+	ca.Target.write(out)
+	out.WriteString(" " + ca.Operator + " ")
+	ca.Value.write(out)
+	out.WriteString(")")
+}
+
+func (ca *CompoundAssign) Pos() int { return ca.Target.Pos() }
+func (ca *CompoundAssign) End() int { return ca.Value.End() }
+
+// TernaryExpression is Condition ? Consequence : Alternative.
+type TernaryExpression struct {
+	Token       token.Token // the ? token
+	Condition   Expression
+	Consequence Expression
+	Alternative Expression
+}
+
+func (te *TernaryExpression) expressionNode()      {}
+func (te *TernaryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TernaryExpression) String() string       { return render(te) }
+func (te *TernaryExpression) write(out *bytes.Buffer) {
+	out.WriteString("(")
+	te.Condition.write(out)
+	out.WriteString(" ? ")
+	te.Consequence.write(out)
+	out.WriteString(" : ")
+	te.Alternative.write(out)
+	out.WriteString(")")
+}
+
+func (te *TernaryExpression) Pos() int { return te.Condition.Pos() }
+func (te *TernaryExpression) End() int { return te.Alternative.End() }
+
+// RangeExpression is Start..Stop with an optional ..Step.
+type RangeExpression struct {
+	Token token.Token // the .. token
+	Start Expression
+	Stop  Expression
+	Step  Expression // nil unless given
+}
+
+func (re *RangeExpression) expressionNode()      {}
+func (re *RangeExpression) TokenLiteral() string { return re.Token.Literal }
+func (re *RangeExpression) String() string       { return render(re) }
+func (re *RangeExpression) write(out *bytes.Buffer) {
+	out.WriteString("(")
+	re.Start.write(out)
+	out.WriteString(" .. ")
+	re.Stop.write(out)
+	if re.Step != nil {
+		out.WriteString(" .. ")
+		re.Step.write(out)
+	}
+	out.WriteString(")")
+}
+
+func (re *RangeExpression) Pos() int { return re.Start.Pos() }
+func (re *RangeExpression) End() int {
+	if re.Step != nil {
+		return re.Step.End()
+	}
+	return re.Stop.End()
+}
+
+// MODULES
+
+// ImportStatement is import "path" with an optional "as alias".
+type ImportStatement struct {
+	Token token.Token // the IMPORT token
+	Path  *StringLiteral
+	Alias *Identifier // nil unless given
+	Trivia
+}
+
+func (is *ImportStatement) statementNode()       {}
+func (is *ImportStatement) TokenLiteral() string { return is.Token.Literal }
+func (is *ImportStatement) String() string       { return render(is) }
+func (is *ImportStatement) write(out *bytes.Buffer) {
+	out.WriteString(is.TokenLiteral() + " ")
+	out.WriteString(strconv.Quote(is.Path.Value))
+	if is.Alias != nil {
+		out.WriteString(" as ")
+		is.Alias.write(out)
+	}
+	out.WriteString(";")
+}
+
+func (is *ImportStatement) Pos() int { return is.Token.Offset }
+func (is *ImportStatement) End() int {
+	if is.Alias != nil {
+		return is.Alias.End()
+	}
+	return is.Path.End()
+}
+
+// MACROS
+
+// QuoteExpression is quote(Node). Node is not evaluated but handed over as
+// syntax, with any UnquoteExpression inside it evaluated and spliced in.
+type QuoteExpression struct {
+	Token  token.Token // the QUOTE token
+	Node   Expression
+	Rparen token.Token // the closing )
+}
+
+func (qe *QuoteExpression) expressionNode()      {}
+func (qe *QuoteExpression) TokenLiteral() string { return qe.Token.Literal }
+func (qe *QuoteExpression) String() string       { return render(qe) }
+func (qe *QuoteExpression) write(out *bytes.Buffer) {
+	out.WriteString("quote(")
+	qe.Node.write(out)
+	out.WriteString(")")
+}
+
+func (qe *QuoteExpression) Pos() int { return qe.Token.Offset }
+func (qe *QuoteExpression) End() int { return qe.Rparen.End }
+
+// UnquoteExpression is unquote(Node) inside a QuoteExpression.
+type UnquoteExpression struct {
+	Token  token.Token // the UNQUOTE token
+	Node   Expression
+	Rparen token.Token // the closing )
+}
+
+func (ue *UnquoteExpression) expressionNode()      {}
+func (ue *UnquoteExpression) TokenLiteral() string { return ue.Token.Literal }
+func (ue *UnquoteExpression) String() string       { return render(ue) }
+func (ue *UnquoteExpression) write(out *bytes.Buffer) {
+	out.WriteString("unquote(")
+	ue.Node.write(out)
+	out.WriteString(")")
+}
+
+func (ue *UnquoteExpression) Pos() int { return ue.Token.Offset }
+func (ue *UnquoteExpression) End() int { return ue.Rparen.End }
+
+// MacroLiteral is macro(Parameters) { Body }. Its calls are replaced by the
+// code it quotes before the program is evaluated.
+type MacroLiteral struct {
+	Token      token.Token // the MACRO token
+	Parameters []*Identifier
+	Body       *BlockStatement
+}
+
+func (ml *MacroLiteral) expressionNode()      {}
+func (ml *MacroLiteral) TokenLiteral() string { return ml.Token.Literal }
+func (ml *MacroLiteral) String() string       { return render(ml) }
+func (ml *MacroLiteral) write(out *bytes.Buffer) {
+	out.WriteString(ml.TokenLiteral())
+	out.WriteString("(")
+	for i, p := range ml.Parameters {
+		if i > 0 {
+			out.WriteString(",")
+		}
+		p.write(out)
+	}
+	out.WriteString(")")
+	ml.Body.write(out)
+}
+
+func (ml *MacroLiteral) Pos() int { return ml.Token.Offset }
+func (ml *MacroLiteral) End() int { return ml.Body.End() }
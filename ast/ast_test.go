@@ -1,7 +1,11 @@
 package ast
 
 import (
+	"bytes"
+	"fmt"
 	"interpreter/token"
+	"maps"
+	"strings"
 	"testing"
 )
 
@@ -28,3 +32,207 @@ func TestString(t *testing.T) {
 	}
 
 }
+
+func TestWrite(t *testing.T) {
+	program := &Program{
+		Statements: []Statement{
+			&ReturnStatement{
+				Token: token.Token{Type: token.RETURN, Literal: "return"},
+				ReturnValue: &InfixExpression{
+					Token:    token.Token{Type: token.PLUS, Literal: "+"},
+					Left:     &Identifier{Token: token.Token{Type: token.IDENT, Literal: "a"}, Value: "a"},
+					Operator: "+",
+					Right: &PrefixExpression{
+						Token:    token.Token{Type: token.MINUS, Literal: "-"},
+						Operator: "-",
+						Right:    &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "5"}, Value: 5},
+					},
+				},
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	n, err := Write(&out, program)
+	if err != nil {
+		t.Fatalf("Write returned error: %v", err)
+	}
+	if out.String() != "return (a + (-5));" || n != out.Len() {
+		t.Errorf("Write wrote %d bytes: %q", n, out.String())
+	}
+	if out.String() != program.String() {
+		t.Errorf("Write and String differ. Write=%q String=%q", out.String(), program.String())
+	}
+}
+
+func TestFormat(t *testing.T) {
+	ident := func(name string) *Identifier {
+		return &Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}
+	}
+	// a * (b + c), built without tokens for the parentheses
+	product := &InfixExpression{
+		Left:     ident("a"),
+		Operator: "*",
+		Right:    &InfixExpression{Left: ident("b"), Operator: "+", Right: ident("c")},
+	}
+	program := &Program{
+		Statements: []Statement{
+			&ReturnStatement{ReturnValue: &PrefixExpression{Operator: "-", Right: product}},
+			&ExpressionStatement{Expression: &CallExpression{Function: ident("f"), Arguments: []Expression{product}}},
+		},
+	}
+
+	want := "return -(a * (b + c));\nf(a * (b + c));\n"
+	if got := Format(program); got != want {
+		t.Errorf("Format(program) = %q, want %q", got, want)
+	}
+	if got := Format(product); got != "a * (b + c)" {
+		t.Errorf("Format(product) = %q", got)
+	}
+}
+
+func TestToDot(t *testing.T) {
+	ident := func(name string) *Identifier { return &Identifier{Value: name} }
+	// let x = 1 + 2 * y;
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Name: ident("x"),
+				Value: &InfixExpression{
+					Left:     &IntegerLiteral{Value: 1},
+					Operator: "+",
+					Right:    &InfixExpression{Left: &IntegerLiteral{Value: 2}, Operator: "*", Right: ident("y")},
+				},
+			},
+		},
+	}
+
+	want := `digraph AST {
+	node [shape=box];
+	n0 [label="Program"];
+	n1 [label="LetStatement\nx"];
+	n2 [label="InfixExpression\n+"];
+	n3 [label="IntegerLiteral\n1"];
+	n2 -> n3 [label="Left"];
+	n4 [label="InfixExpression\n*"];
+	n5 [label="IntegerLiteral\n2"];
+	n4 -> n5 [label="Left"];
+	n6 [label="Identifier\ny"];
+	n4 -> n6 [label="Right"];
+	n2 -> n4 [label="Right"];
+	n1 -> n2 [label="Value"];
+	n0 -> n1 [label="Statements[0]"];
+}
+`
+	if got := ToDot(program); got != want {
+		t.Errorf("ToDot got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	integer := func(v int64) *IntegerLiteral { return &IntegerLiteral{Value: v} }
+	// 1 + 2 * 3, folded bottom-up into 7
+	sum := &InfixExpression{
+		Left:     integer(1),
+		Operator: "+",
+		Right:    &InfixExpression{Left: integer(2), Operator: "*", Right: integer(3)},
+	}
+	program := &Program{Statements: []Statement{&ExpressionStatement{Expression: sum}}}
+
+	fold := func(node Node) Node {
+		infix, ok := node.(*InfixExpression)
+		if !ok {
+			return node
+		}
+		left, lok := infix.Left.(*IntegerLiteral)
+		right, rok := infix.Right.(*IntegerLiteral)
+		if !lok || !rok {
+			return node
+		}
+		switch infix.Operator {
+		case "+":
+			return integer(left.Value + right.Value)
+		case "*":
+			return integer(left.Value * right.Value)
+		}
+		return node
+	}
+
+	folded := Rewrite(program, fold).(*Program)
+	if got := Format(folded); got != "7;\n" {
+		t.Errorf("folded program = %q, want %q", got, "7;\n")
+	}
+	if got := Format(program); got != "1 + 2 * 3;\n" {
+		t.Errorf("Rewrite changed its input to %q", got)
+	}
+
+	// class Vec { fn len() { x } fn operator +(o) { o.x } }
+	ident := func(name string) *Identifier { return &Identifier{Value: name} }
+	block := func(e Expression) *BlockStatement {
+		return &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: e}}}
+	}
+	class := &ClassStatement{
+		Name: ident("Vec"),
+		Methods: []*FunctionStatement{{
+			Name:     ident("len"),
+			Function: &FunctionLiteral{Body: block(ident("x"))},
+		}},
+		Operators: []*OperatorMethod{{
+			Operator: "+",
+			Function: &FunctionLiteral{
+				Parameters: []*Identifier{ident("o")},
+				Body:       block(&MemberExpression{Object: ident("o"), Property: ident("x")}),
+			},
+		}},
+	}
+	visited := map[string]int{}
+	Rewrite(class, func(node Node) Node {
+		visited[strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")]++
+		return node
+	})
+	want := map[string]int{
+		"ClassStatement":      1,
+		"FunctionStatement":   1,
+		"OperatorMethod":      1,
+		"FunctionLiteral":     2,
+		"BlockStatement":      2,
+		"ExpressionStatement": 2,
+		"MemberExpression":    1,
+		// Vec, len, x, the parameter o, o and .x
+		"Identifier": 6,
+	}
+	if !maps.Equal(visited, want) {
+		t.Errorf("Rewrite visited %v, want %v", visited, want)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	ident := func(name string, offset int) *Identifier {
+		return &Identifier{Token: token.Token{Type: token.IDENT, Literal: name, Offset: offset}, Value: name}
+	}
+	call := func(offset int, args ...Expression) *CallExpression {
+		return &CallExpression{Function: ident("f", offset), Arguments: args}
+	}
+
+	tests := []struct {
+		a, b Node
+		want bool
+	}{
+		{nil, nil, true},
+		{ident("x", 0), nil, false},
+		{ident("x", 0), ident("x", 7), true},
+		{ident("x", 0), ident("y", 0), false},
+		{call(0, ident("a", 2)), call(5, ident("a", 9)), true},
+		{call(0, ident("a", 2)), call(0, ident("a", 2), ident("b", 4)), false},
+		{&IfExpression{Condition: ident("c", 0), Consequence: &BlockStatement{}},
+			&IfExpression{Condition: ident("c", 0), Consequence: &BlockStatement{}, Alternative: &BlockStatement{}}, false},
+		{&PrefixExpression{Operator: "-", Right: ident("x", 0)}, &PrefixExpression{Operator: "!", Right: ident("x", 0)}, false},
+		{&IntegerLiteral{Value: 1}, &Boolean{Value: true}, false},
+	}
+
+	for i, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.want {
+			t.Errorf("tests[%d]: Equal = %t, want %t", i, got, tt.want)
+		}
+	}
+}
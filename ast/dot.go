@@ -0,0 +1,239 @@
+package ast
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ToDot renders program as a Graphviz DOT graph, one box per node labeled
+// with its type and its operator, name or value, and an edge to each child
+// labeled with the field holding it. Feeding the output to dot, as in
+//
+//	dot -Tsvg program.dot > program.svg
+//
+// draws the tree, which shows how precedence grouped each expression.
+func ToDot(program *Program) string {
+	d := dotWriter{}
+	d.out.WriteString("digraph AST {\n\tnode [shape=box];\n")
+	d.node(program)
+	d.out.WriteString("}\n")
+	return d.out.String()
+}
+
+// dotWriter accumulates the graph, numbering nodes in the order visited.
+type dotWriter struct {
+	out  strings.Builder
+	next int
+}
+
+// node writes n and everything below it and returns n's id.
+func (d *dotWriter) node(n Node) string {
+	id := "n" + strconv.Itoa(d.next)
+	d.next++
+	label := strings.TrimPrefix(fmt.Sprintf("%T", n), "*ast.")
+	if detail := dotDetail(n); detail != "" {
+		label += "\n" + detail
+	}
+	fmt.Fprintf(&d.out, "\t%s [label=%s];\n", id, strconv.Quote(label))
+
+	edge := func(field string, child Node) {
+		fmt.Fprintf(&d.out, "\t%s -> %s [label=%s];\n", id, d.node(child), strconv.Quote(field))
+	}
+	expr := func(field string, child Expression) {
+		if child != nil {
+			edge(field, child)
+		}
+	}
+	switch n := n.(type) {
+	case *Program:
+		for i, s := range n.Statements {
+			edge(fmt.Sprintf("Statements[%d]", i), s)
+		}
+	case *BlockStatement:
+		for i, s := range n.Statements {
+			edge(fmt.Sprintf("Statements[%d]", i), s)
+		}
+	case *LetStatement:
+		expr("Value", n.Value)
+	case *ReturnStatement:
+		expr("ReturnValue", n.ReturnValue)
+	case *ExpressionStatement:
+		expr("Expression", n.Expression)
+	case *AssertStatement:
+		expr("Condition", n.Condition)
+		expr("Message", n.Message)
+	case *YieldStatement:
+		expr("Value", n.Value)
+	case *FunctionStatement:
+		edge("Function", n.Function)
+	case *OperatorMethod:
+		edge("Function", n.Function)
+	case *ClassStatement:
+		methods, operators := 0, 0
+		for _, m := range n.Members() {
+			if _, ok := m.(*OperatorMethod); ok {
+				edge(fmt.Sprintf("Operators[%d]", operators), m)
+				operators++
+			} else {
+				edge(fmt.Sprintf("Methods[%d]", methods), m)
+				methods++
+			}
+		}
+	case *WhileStatement:
+		expr("Condition", n.Condition)
+		edge("Body", n.Body)
+	case *DoWhileStatement:
+		edge("Body", n.Body)
+		expr("Condition", n.Condition)
+	case *ForStatement:
+		if n.Init != nil {
+			edge("Init", n.Init)
+		}
+		expr("Condition", n.Condition)
+		if n.Post != nil {
+			edge("Post", n.Post)
+		}
+		edge("Body", n.Body)
+	case *ForInStatement:
+		expr("Iterable", n.Iterable)
+		edge("Body", n.Body)
+	case *PrefixExpression:
+		expr("Right", n.Right)
+	case *InfixExpression:
+		expr("Left", n.Left)
+		expr("Right", n.Right)
+	case *CompoundAssign:
+		expr("Target", n.Target)
+		expr("Value", n.Value)
+	case *TernaryExpression:
+		expr("Condition", n.Condition)
+		expr("Consequence", n.Consequence)
+		expr("Alternative", n.Alternative)
+	case *PipeExpression:
+		expr("Value", n.Value)
+		expr("Function", n.Function)
+	case *RangeExpression:
+		expr("Start", n.Start)
+		expr("Stop", n.Stop)
+		expr("Step", n.Step)
+	case *IfExpression:
+		expr("Condition", n.Condition)
+		edge("Consequence", n.Consequence)
+		if n.Alternative != nil {
+			edge("Alternative", n.Alternative)
+		}
+	case *FunctionLiteral:
+		edge("Body", n.Body)
+	case *MacroLiteral:
+		edge("Body", n.Body)
+	case *CallExpression:
+		expr("Function", n.Function)
+		for i, a := range n.Arguments {
+			edge(fmt.Sprintf("Arguments[%d]", i), a)
+		}
+	case *NamedArgument:
+		expr("Value", n.Value)
+	case *MemberExpression:
+		expr("Object", n.Object)
+	case *TupleIndexExpression:
+		expr("Tuple", n.Tuple)
+	case *IndexExpression:
+		expr("Left", n.Left)
+		expr("Index", n.Index)
+	case *SliceExpression:
+		expr("Left", n.Left)
+		expr("Low", n.Low)
+		expr("High", n.High)
+	case *TupleLiteral:
+		for i, e := range n.Elements {
+			edge(fmt.Sprintf("Elements[%d]", i), e)
+		}
+	case *InterpolatedString:
+		for i, part := range n.Parts {
+			edge(fmt.Sprintf("Parts[%d]", i), part)
+		}
+	case *HashLiteral:
+		for i, pair := range n.Pairs {
+			edge(fmt.Sprintf("Pairs[%d].Key", i), pair.Key)
+			edge(fmt.Sprintf("Pairs[%d].Value", i), pair.Value)
+		}
+	case *StructLiteral:
+		for _, f := range n.Fields {
+			edge(f.Name.Value, f.Value)
+		}
+	case *QuoteExpression:
+		expr("Node", n.Node)
+	case *UnquoteExpression:
+		expr("Node", n.Node)
+	}
+	return id
+}
+
+// dotDetail returns what tells n apart from other nodes of its type, such as
+// an operator or a name, or "" if its type says it all.
+func dotDetail(n Node) string {
+	switch n := n.(type) {
+	case *Identifier:
+		return n.Value
+	case *IntegerLiteral:
+		return strconv.FormatInt(n.Value, 10)
+	case *Boolean:
+		return strconv.FormatBool(n.Value)
+	case *StringLiteral:
+		return strconv.Quote(n.Value)
+	case *RegexLiteral:
+		return "/" + n.Pattern + "/" + n.Flags
+	case *PrefixExpression:
+		return n.Operator
+	case *InfixExpression:
+		return n.Operator
+	case *CompoundAssign:
+		return n.Operator
+	case *LetStatement:
+		return identifiers(letNames(n))
+	case *FunctionStatement:
+		return n.Name.Value
+	case *FunctionLiteral:
+		return "(" + identifiers(n.Parameters) + variadic(n.Variadic) + ")"
+	case *MacroLiteral:
+		return "(" + identifiers(n.Parameters) + ")"
+	case *OperatorMethod:
+		return n.Operator
+	case *ClassStatement:
+		return n.Name.Value
+	case *StructStatement:
+		return n.Name.Value + " { " + identifiers(n.Fields) + " }"
+	case *StructLiteral:
+		return n.Type.Value
+	case *ForInStatement:
+		return n.Binding.Value
+	case *NamedArgument:
+		return n.Name.Value
+	case *MemberExpression:
+		return n.Property.Value
+	case *TupleIndexExpression:
+		return strconv.FormatInt(n.Index.Value, 10)
+	case *ImportStatement:
+		if n.Alias != nil {
+			return strconv.Quote(n.Path.Value) + " as " + n.Alias.Value
+		}
+		return strconv.Quote(n.Path.Value)
+	}
+	return ""
+}
+
+func identifiers(idents []*Identifier) string {
+	names := make([]string, len(idents))
+	for i, ident := range idents {
+		names[i] = ident.Value
+	}
+	return strings.Join(names, ", ")
+}
+
+func variadic(v bool) string {
+	if v {
+		return "..."
+	}
+	return ""
+}
@@ -0,0 +1,246 @@
+package ast
+
+// Equal reports whether a and b are the same tree: nodes of the same types
+// with the same operators, names and literal values. Tokens are ignored, so
+// trees parsed from differently laid out source, or built by hand without
+// tokens, compare equal. So are comments. Two nil nodes are equal.
+func Equal(a, b Node) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	switch a := a.(type) {
+	case *Program:
+		b, ok := b.(*Program)
+		return ok && equalStatements(a.Statements, b.Statements)
+	case *BlockStatement:
+		b, ok := b.(*BlockStatement)
+		return ok && equalBlocks(a, b)
+	case *LetStatement:
+		b, ok := b.(*LetStatement)
+		return ok && equalIdentifiers(letNames(a), letNames(b)) && Equal(a.Value, b.Value)
+	case *ReturnStatement:
+		b, ok := b.(*ReturnStatement)
+		return ok && Equal(a.ReturnValue, b.ReturnValue)
+	case *ExpressionStatement:
+		b, ok := b.(*ExpressionStatement)
+		return ok && Equal(a.Expression, b.Expression)
+	case *AssertStatement:
+		b, ok := b.(*AssertStatement)
+		return ok && Equal(a.Condition, b.Condition) && Equal(a.Message, b.Message)
+	case *YieldStatement:
+		b, ok := b.(*YieldStatement)
+		return ok && Equal(a.Value, b.Value)
+	case *ImportStatement:
+		b, ok := b.(*ImportStatement)
+		return ok && a.Path.Value == b.Path.Value && equalIdentifier(a.Alias, b.Alias)
+	case *FunctionStatement:
+		b, ok := b.(*FunctionStatement)
+		return ok && equalIdentifier(a.Name, b.Name) && equalFunctions(a.Function, b.Function)
+	case *OperatorMethod:
+		b, ok := b.(*OperatorMethod)
+		return ok && a.Operator == b.Operator && equalFunctions(a.Function, b.Function)
+	case *ClassStatement:
+		b, ok := b.(*ClassStatement)
+		if !ok || !equalIdentifier(a.Name, b.Name) ||
+			len(a.Methods) != len(b.Methods) || len(a.Operators) != len(b.Operators) {
+			return false
+		}
+		for i := range a.Methods {
+			if !Equal(a.Methods[i], b.Methods[i]) {
+				return false
+			}
+		}
+		for i := range a.Operators {
+			if !Equal(a.Operators[i], b.Operators[i]) {
+				return false
+			}
+		}
+		return true
+	case *StructStatement:
+		b, ok := b.(*StructStatement)
+		return ok && equalIdentifier(a.Name, b.Name) && equalIdentifiers(a.Fields, b.Fields)
+	case *WhileStatement:
+		b, ok := b.(*WhileStatement)
+		return ok && Equal(a.Condition, b.Condition) && equalBlocks(a.Body, b.Body)
+	case *DoWhileStatement:
+		b, ok := b.(*DoWhileStatement)
+		return ok && equalBlocks(a.Body, b.Body) && Equal(a.Condition, b.Condition)
+	case *ForStatement:
+		b, ok := b.(*ForStatement)
+		return ok && Equal(a.Init, b.Init) && Equal(a.Condition, b.Condition) &&
+			Equal(a.Post, b.Post) && equalBlocks(a.Body, b.Body)
+	case *ForInStatement:
+		b, ok := b.(*ForInStatement)
+		return ok && equalIdentifier(a.Binding, b.Binding) && Equal(a.Iterable, b.Iterable) &&
+			equalBlocks(a.Body, b.Body)
+	case *Identifier:
+		b, ok := b.(*Identifier)
+		return ok && equalIdentifier(a, b)
+	case *IntegerLiteral:
+		b, ok := b.(*IntegerLiteral)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *NullLiteral:
+		_, ok := b.(*NullLiteral)
+		return ok
+	case *StringLiteral:
+		b, ok := b.(*StringLiteral)
+		return ok && a.Value == b.Value
+	case *RegexLiteral:
+		b, ok := b.(*RegexLiteral)
+		return ok && a.Pattern == b.Pattern && a.Flags == b.Flags
+	case *InterpolatedString:
+		b, ok := b.(*InterpolatedString)
+		return ok && equalExpressions(a.Parts, b.Parts)
+	case *PrefixExpression:
+		b, ok := b.(*PrefixExpression)
+		return ok && a.Operator == b.Operator && Equal(a.Right, b.Right)
+	case *InfixExpression:
+		b, ok := b.(*InfixExpression)
+		return ok && a.Operator == b.Operator && Equal(a.Left, b.Left) && Equal(a.Right, b.Right)
+	case *CompoundAssign:
+		b, ok := b.(*CompoundAssign)
+		return ok && a.Operator == b.Operator && Equal(a.Target, b.Target) && Equal(a.Value, b.Value)
+	case *TernaryExpression:
+		b, ok := b.(*TernaryExpression)
+		return ok && Equal(a.Condition, b.Condition) && Equal(a.Consequence, b.Consequence) &&
+			Equal(a.Alternative, b.Alternative)
+	case *PipeExpression:
+		b, ok := b.(*PipeExpression)
+		return ok && Equal(a.Value, b.Value) && Equal(a.Function, b.Function)
+	case *RangeExpression:
+		b, ok := b.(*RangeExpression)
+		return ok && Equal(a.Start, b.Start) && Equal(a.Stop, b.Stop) && Equal(a.Step, b.Step)
+	case *IfExpression:
+		b, ok := b.(*IfExpression)
+		return ok && Equal(a.Condition, b.Condition) && equalBlocks(a.Consequence, b.Consequence) &&
+			equalBlocks(a.Alternative, b.Alternative)
+	case *FunctionLiteral:
+		b, ok := b.(*FunctionLiteral)
+		return ok && equalFunctions(a, b)
+	case *MacroLiteral:
+		b, ok := b.(*MacroLiteral)
+		return ok && equalIdentifiers(a.Parameters, b.Parameters) && equalBlocks(a.Body, b.Body)
+	case *CallExpression:
+		b, ok := b.(*CallExpression)
+		return ok && Equal(a.Function, b.Function) && equalExpressions(a.Arguments, b.Arguments)
+	case *NamedArgument:
+		b, ok := b.(*NamedArgument)
+		return ok && equalIdentifier(a.Name, b.Name) && Equal(a.Value, b.Value)
+	case *TupleLiteral:
+		b, ok := b.(*TupleLiteral)
+		return ok && equalExpressions(a.Elements, b.Elements)
+	case *TupleIndexExpression:
+		b, ok := b.(*TupleIndexExpression)
+		return ok && Equal(a.Tuple, b.Tuple) && a.Index.Value == b.Index.Value
+	case *MemberExpression:
+		b, ok := b.(*MemberExpression)
+		return ok && Equal(a.Object, b.Object) && equalIdentifier(a.Property, b.Property)
+	case *IndexExpression:
+		b, ok := b.(*IndexExpression)
+		return ok && Equal(a.Left, b.Left) && Equal(a.Index, b.Index)
+	case *SliceExpression:
+		b, ok := b.(*SliceExpression)
+		return ok && Equal(a.Left, b.Left) && Equal(a.Low, b.Low) && Equal(a.High, b.High)
+	case *HashLiteral:
+		b, ok := b.(*HashLiteral)
+		if !ok || len(a.Pairs) != len(b.Pairs) {
+			return false
+		}
+		for i := range a.Pairs {
+			if !Equal(a.Pairs[i].Key, b.Pairs[i].Key) || !Equal(a.Pairs[i].Value, b.Pairs[i].Value) {
+				return false
+			}
+		}
+		return true
+	case *StructLiteral:
+		b, ok := b.(*StructLiteral)
+		if !ok || !equalIdentifier(a.Type, b.Type) || len(a.Fields) != len(b.Fields) {
+			return false
+		}
+		for i := range a.Fields {
+			if !equalIdentifier(a.Fields[i].Name, b.Fields[i].Name) || !Equal(a.Fields[i].Value, b.Fields[i].Value) {
+				return false
+			}
+		}
+		return true
+	case *QuoteExpression:
+		b, ok := b.(*QuoteExpression)
+		return ok && Equal(a.Node, b.Node)
+	case *UnquoteExpression:
+		b, ok := b.(*UnquoteExpression)
+		return ok && Equal(a.Node, b.Node)
+	}
+	return false
+}
+
+// The helpers below take the pointer types of optional fields, which would
+// not compare as nil once turned into a Node.
+
+func equalIdentifier(a, b *Identifier) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Value == b.Value
+}
+
+func equalIdentifiers(a, b []*Identifier) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !equalIdentifier(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalBlocks(a, b *BlockStatement) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return equalStatements(a.Statements, b.Statements)
+}
+
+func equalFunctions(a, b *FunctionLiteral) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return equalIdentifiers(a.Parameters, b.Parameters) && a.Variadic == b.Variadic &&
+		a.Generator == b.Generator && equalBlocks(a.Body, b.Body)
+}
+
+func equalStatements(a, b []Statement) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalExpressions(a, b []Expression) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// letNames returns the names a let binds, whether or not Names is filled in.
+func letNames(s *LetStatement) []*Identifier {
+	if len(s.Names) == 0 {
+		return []*Identifier{s.Name}
+	}
+	return s.Names
+}
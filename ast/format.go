@@ -0,0 +1,602 @@
+package ast
+
+import (
+	"fmt"
+	"interpreter/token"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Format renders node as source code in the canonical layout: one statement
+// per line, blocks indented with tabs, operators spaced and only the
+// parentheses the grouping needs. Argument lists, hashes, tuples and struct
+// literals that would run past 80 columns are wrapped, one element per line.
+// Comments kept by the parser are written back where they were attached, see
+// Trivia. A formatted Program ends in a newline; other nodes don't.
+//
+// Unlike String, whose output is meant for tests and debugging, the result
+// parses back into the same tree.
+func Format(node Node) string {
+	var f formatter
+	if program, ok := node.(*Program); ok {
+		f.statements(program.Statements)
+		if len(program.Statements) > 0 {
+			f.print("\n")
+		}
+		return f.out.String()
+	}
+	switch n := node.(type) {
+	case Expression:
+		f.expression(n, precLowest)
+	case Statement:
+		f.statement(n)
+	default:
+		f.print(node.String())
+	}
+	return f.out.String()
+}
+
+const (
+	lineWidth = 80 // columns after which lists are wrapped
+	tabWidth  = 4  // columns a tab of indentation is counted as
+)
+
+// Binding strengths of the expressions, mirroring the parser's precedences.
+// An operand binding more loosely than its position allows is parenthesized.
+const (
+	precLowest = iota
+	precAssign
+	precTernary
+	precPipe
+	precLogicalOr
+	precLogicalAnd
+	precEquals
+	precLessGreater
+	precBitwiseOr
+	precBitwiseXor
+	precBitwiseAnd
+	precShift
+	precRange
+	precSum
+	precProduct
+	precExponent
+	precPrefix
+	precPostfix // calls, member access and indexing
+	precAtom
+)
+
+var infixPrecedences = map[string]int{
+	"||": precLogicalOr,
+	"&&": precLogicalAnd,
+	"==": precEquals,
+	"!=": precEquals,
+	"<":  precLessGreater,
+	">":  precLessGreater,
+	"|":  precBitwiseOr,
+	"^":  precBitwiseXor,
+	"&":  precBitwiseAnd,
+	"<<": precShift,
+	">>": precShift,
+	"+":  precSum,
+	"-":  precSum,
+	"*":  precProduct,
+	"/":  precProduct,
+	"**": precExponent,
+}
+
+// formatter accumulates formatted source, keeping track of the column so
+// lists can be wrapped.
+type formatter struct {
+	out    strings.Builder
+	indent int
+	col    int
+	flat   bool // never wrap, set when measuring
+}
+
+func (f *formatter) print(s string) {
+	f.out.WriteString(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		f.col = len(s) - i - 1
+	} else {
+		f.col += len(s)
+	}
+}
+
+// newline starts a new line at the current indentation.
+func (f *formatter) newline() {
+	f.out.WriteByte('\n')
+	f.out.WriteString(strings.Repeat("\t", f.indent))
+	f.col = f.indent * tabWidth
+}
+
+// statements writes stmts one per line, setting declarations off with blank
+// lines.
+func (f *formatter) statements(stmts []Statement) {
+	for i, s := range stmts {
+		if i > 0 {
+			if isDeclaration(s) || isDeclaration(stmts[i-1]) {
+				f.out.WriteByte('\n')
+			}
+			f.newline()
+		}
+		f.statement(s)
+	}
+}
+
+func isDeclaration(s Statement) bool {
+	switch s.(type) {
+	case *FunctionStatement, *ClassStatement, *StructStatement:
+		return true
+	}
+	return false
+}
+
+// statement writes s with its leading and trailing comments.
+func (f *formatter) statement(s Statement) {
+	var trivia *Trivia
+	if c, ok := s.(Commented); ok {
+		trivia = c.Comments()
+		for _, comment := range trivia.Leading {
+			f.print(comment.Literal)
+			f.newline()
+		}
+	}
+	switch s := s.(type) {
+	case *LetStatement:
+		f.let(s)
+		f.print(";")
+	case *ReturnStatement:
+		f.print("return")
+		if s.ReturnValue != nil {
+			f.print(" ")
+			f.valueList(s.ReturnValue)
+		}
+		f.print(";")
+	case *ExpressionStatement:
+		if s.Expression != nil {
+			f.expression(s.Expression, precLowest)
+			if _, ok := s.Expression.(*IfExpression); !ok {
+				f.print(";")
+			}
+		}
+	case *AssertStatement:
+		f.print("assert ")
+		f.expression(s.Condition, precLowest)
+		if s.Message != nil {
+			f.print(", ")
+			f.expression(s.Message, precLowest)
+		}
+		f.print(";")
+	case *YieldStatement:
+		f.print("yield ")
+		f.expression(s.Value, precLowest)
+		f.print(";")
+	case *ImportStatement:
+		f.print("import " + quote(s.Path.Value))
+		if s.Alias != nil {
+			f.print(" as " + s.Alias.Value)
+		}
+		f.print(";")
+	case *FunctionStatement:
+		f.print("fn " + s.Name.Value)
+		f.function(s.Function)
+	case *OperatorMethod:
+		f.print("fn operator " + s.Operator)
+		f.function(s.Function)
+	case *ClassStatement:
+		f.class(s)
+	case *StructStatement:
+		f.print("struct " + s.Name.Value + " {")
+		for i, field := range s.Fields {
+			if i > 0 {
+				f.print(",")
+			}
+			f.print(" " + field.Value)
+		}
+		if len(s.Fields) > 0 {
+			f.print(" ")
+		}
+		f.print("}")
+	case *WhileStatement:
+		f.print("while (")
+		f.expression(s.Condition, precLowest)
+		f.print(") ")
+		f.block(s.Body)
+	case *DoWhileStatement:
+		f.print("do ")
+		f.block(s.Body)
+		f.print(" while (")
+		f.expression(s.Condition, precLowest)
+		f.print(");")
+	case *ForStatement:
+		f.print("for (")
+		f.clause(s.Init)
+		f.print(";")
+		if s.Condition != nil {
+			f.print(" ")
+			f.expression(s.Condition, precLowest)
+		}
+		f.print(";")
+		if s.Post != nil {
+			f.print(" ")
+			f.clause(s.Post)
+		}
+		f.print(") ")
+		f.block(s.Body)
+	case *ForInStatement:
+		f.print("for (" + s.Binding.Value + " in ")
+		f.expression(s.Iterable, precLowest)
+		f.print(") ")
+		f.block(s.Body)
+	case *BlockStatement:
+		f.block(s)
+	default:
+		f.print(s.String())
+	}
+	if trivia != nil {
+		for _, comment := range trivia.Trailing {
+			f.print(" " + comment.Literal)
+		}
+	}
+}
+
+func (f *formatter) let(s *LetStatement) {
+	f.print("let ")
+	for i, name := range letNames(s) {
+		if i > 0 {
+			f.print(", ")
+		}
+		f.print(name.Value)
+	}
+	f.print(" = ")
+	f.valueList(s.Value)
+}
+
+// valueList writes the value of a let or return, where a tuple written
+// without parentheses, as in return a, b;, stays that way.
+func (f *formatter) valueList(value Expression) {
+	tuple, ok := value.(*TupleLiteral)
+	if !ok || tuple.Token.Type == token.LPAREN || len(tuple.Elements) < 2 {
+		f.expression(value, precLowest)
+		return
+	}
+	for i, e := range tuple.Elements {
+		if i > 0 {
+			f.print(", ")
+		}
+		f.expression(e, precLowest)
+	}
+}
+
+// clause writes a for loop clause, which has no semicolon of its own.
+func (f *formatter) clause(s Statement) {
+	switch s := s.(type) {
+	case nil:
+	case *LetStatement:
+		f.let(s)
+	case *ExpressionStatement:
+		f.expression(s.Expression, precLowest)
+	default:
+		f.statement(s)
+	}
+}
+
+func (f *formatter) block(b *BlockStatement) {
+	if len(b.Statements) == 0 {
+		f.print("{}")
+		return
+	}
+	f.print("{")
+	f.indent++
+	f.newline()
+	f.statements(b.Statements)
+	f.indent--
+	f.newline()
+	f.print("}")
+}
+
+func (f *formatter) function(fn *FunctionLiteral) {
+	f.parameters(fn.Parameters, fn.Variadic)
+	f.print(" ")
+	f.block(fn.Body)
+}
+
+func (f *formatter) parameters(params []*Identifier, variadic bool) {
+	f.print("(")
+	for i, p := range params {
+		if i > 0 {
+			f.print(", ")
+		}
+		f.print(p.Value)
+	}
+	if variadic {
+		f.print("...")
+	}
+	f.print(")")
+}
+
+func (f *formatter) class(s *ClassStatement) {
+	f.print("class " + s.Name.Value + " {")
+	members := s.Members()
+	if len(members) == 0 {
+		f.print("}")
+		return
+	}
+	f.indent++
+	for i, m := range members {
+		if i > 0 {
+			f.out.WriteByte('\n')
+		}
+		f.newline()
+		f.statement(m)
+	}
+	f.indent--
+	f.newline()
+	f.print("}")
+}
+
+// binding returns how tightly e holds together as an operand.
+func binding(e Expression) int {
+	switch e := e.(type) {
+	case *CompoundAssign:
+		return precAssign
+	case *TernaryExpression:
+		return precTernary
+	case *PipeExpression:
+		return precPipe
+	case *RangeExpression:
+		return precRange
+	case *InfixExpression:
+		return infixPrecedences[e.Operator] // precLowest for custom operators
+	case *PrefixExpression:
+		return precPrefix
+	case *CallExpression, *MemberExpression, *TupleIndexExpression, *IndexExpression, *SliceExpression:
+		return precPostfix
+	}
+	return precAtom
+}
+
+// expression writes e, parenthesized if it binds more loosely than min.
+func (f *formatter) expression(e Expression, min int) {
+	if binding(e) < min {
+		f.print("(")
+		defer f.print(")")
+	}
+	switch e := e.(type) {
+	case *Identifier:
+		f.print(e.Value)
+	case *IntegerLiteral:
+		f.print(strconv.FormatInt(e.Value, 10))
+	case *Boolean:
+		f.print(strconv.FormatBool(e.Value))
+	case *NullLiteral:
+		f.print("null")
+	case *StringLiteral:
+		if e.Token.Type == token.RAW_STRING {
+			f.print("`" + e.Value + "`")
+		} else {
+			f.print(quote(e.Value))
+		}
+	case *InterpolatedString:
+		f.print(`"`)
+		for _, part := range e.Parts {
+			if text, ok := part.(*StringLiteral); ok {
+				f.print(escape(text.Value))
+				continue
+			}
+			f.print("${")
+			f.expression(part, precLowest)
+			f.print("}")
+		}
+		f.print(`"`)
+	case *RegexLiteral:
+		f.print("/" + e.Pattern + "/" + e.Flags)
+	case *PrefixExpression:
+		f.print(e.Operator)
+		f.expression(e.Right, precPrefix)
+	case *InfixExpression:
+		left, right := precPrefix, precPrefix
+		if prec, ok := infixPrecedences[e.Operator]; ok {
+			left, right = prec, prec+1
+			switch prec {
+			case precExponent: // groups right to left
+				left, right = prec+1, prec
+			case precEquals, precLessGreater: // comparisons don't chain
+				left = prec + 1
+			}
+		}
+		f.expression(e.Left, left)
+		f.print(" " + e.Operator + " ")
+		f.expression(e.Right, right)
+	case *CompoundAssign:
+		f.expression(e.Target, precAssign+1)
+		f.print(" " + e.Operator + " ")
+		f.expression(e.Value, precAssign)
+	case *TernaryExpression:
+		f.expression(e.Condition, precTernary+1)
+		f.print(" ? ")
+		f.expression(e.Consequence, precLowest)
+		f.print(" : ")
+		f.expression(e.Alternative, precTernary)
+	case *PipeExpression:
+		f.expression(e.Value, precPipe)
+		f.print(" |> ")
+		f.expression(e.Function, precPipe+1)
+	case *RangeExpression:
+		f.expression(e.Start, precRange+1)
+		f.print("..")
+		f.expression(e.Stop, precRange+1)
+		if e.Step != nil {
+			f.print("..")
+			f.expression(e.Step, precRange+1)
+		}
+	case *CallExpression:
+		f.expression(e.Function, precPostfix)
+		f.list("(", ")", len(e.Arguments), false, func(f *formatter, i int) {
+			f.expression(e.Arguments[i], precLowest)
+		})
+	case *NamedArgument:
+		f.print(e.Name.Value + ": ")
+		f.expression(e.Value, precLowest)
+	case *MemberExpression:
+		f.expression(e.Object, precPostfix)
+		f.print("." + e.Property.Value)
+	case *TupleIndexExpression:
+		f.expression(e.Tuple, precPostfix)
+		f.print("." + strconv.FormatInt(e.Index.Value, 10))
+	case *IndexExpression:
+		f.expression(e.Left, precPostfix)
+		f.print("[")
+		f.expression(e.Index, precLowest)
+		f.print("]")
+	case *SliceExpression:
+		f.expression(e.Left, precPostfix)
+		f.print("[")
+		if e.Low != nil {
+			f.expression(e.Low, precLowest)
+		}
+		f.print(":")
+		if e.High != nil {
+			f.expression(e.High, precLowest)
+		}
+		f.print("]")
+	case *TupleLiteral:
+		if len(e.Elements) == 1 {
+			f.print("(")
+			f.expression(e.Elements[0], precLowest)
+			f.print(",)") // (x) would read back as a grouped x
+			break
+		}
+		f.list("(", ")", len(e.Elements), true, func(f *formatter, i int) {
+			f.expression(e.Elements[i], precLowest)
+		})
+	case *HashLiteral:
+		f.list("{", "}", len(e.Pairs), true, func(f *formatter, i int) {
+			f.expression(e.Pairs[i].Key, precLowest)
+			f.print(": ")
+			f.expression(e.Pairs[i].Value, precLowest)
+		})
+	case *StructLiteral:
+		f.print(e.Type.Value)
+		f.list("{", "}", len(e.Fields), true, func(f *formatter, i int) {
+			f.print(e.Fields[i].Name.Value + ": ")
+			f.expression(e.Fields[i].Value, precLowest)
+		})
+	case *IfExpression:
+		f.ifExpression(e)
+	case *FunctionLiteral:
+		f.print("fn")
+		f.function(e)
+	case *MacroLiteral:
+		f.print("macro")
+		f.parameters(e.Parameters, false)
+		f.print(" ")
+		f.block(e.Body)
+	case *QuoteExpression:
+		f.print("quote(")
+		f.expression(e.Node, precLowest)
+		f.print(")")
+	case *UnquoteExpression:
+		f.print("unquote(")
+		f.expression(e.Node, precLowest)
+		f.print(")")
+	default:
+		f.print(e.String())
+	}
+}
+
+func (f *formatter) ifExpression(e *IfExpression) {
+	f.print("if (")
+	f.expression(e.Condition, precLowest)
+	f.print(") ")
+	f.block(e.Consequence)
+	if e.Alternative == nil {
+		return
+	}
+	f.print(" else ")
+	if elseIf := elseIf(e.Alternative); elseIf != nil {
+		f.ifExpression(elseIf)
+		return
+	}
+	f.block(e.Alternative)
+}
+
+// elseIf returns the if of an else if, which the parser wraps in a block of
+// its own, or nil if alt is a block written out.
+func elseIf(alt *BlockStatement) *IfExpression {
+	if alt.Token.Type != token.IF || len(alt.Statements) != 1 {
+		return nil
+	}
+	if s, ok := alt.Statements[0].(*ExpressionStatement); ok {
+		e, _ := s.Expression.(*IfExpression)
+		return e
+	}
+	return nil
+}
+
+// list writes n items between open and close, separated by commas. They stay
+// on one line if it fits in lineWidth; otherwise each goes on a line of its
+// own, followed by a comma when trailing allows one after the last.
+func (f *formatter) list(open, close string, n int, trailing bool, item func(f *formatter, i int)) {
+	wrap := false
+	if n > 0 && !f.flat {
+		m := &formatter{indent: f.indent, col: f.col, flat: true}
+		m.list(open, close, n, trailing, item)
+		first, _, _ := strings.Cut(m.out.String(), "\n")
+		wrap = f.col+len(first) > lineWidth
+	}
+	f.print(open)
+	if !wrap {
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				f.print(", ")
+			}
+			item(f, i)
+		}
+		f.print(close)
+		return
+	}
+	f.indent++
+	for i := 0; i < n; i++ {
+		f.newline()
+		item(f, i)
+		if i < n-1 || trailing {
+			f.print(",")
+		}
+	}
+	f.indent--
+	f.newline()
+	f.print(close)
+}
+
+// quote returns s as a double-quoted string literal.
+func quote(s string) string {
+	return `"` + escape(s) + `"`
+}
+
+// escape returns s with the characters that can't appear as they are in a
+// double-quoted string replaced by escape sequences.
+func escape(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '"' || r == '\\':
+			out.WriteByte('\\')
+			out.WriteRune(r)
+		case r == '\n':
+			out.WriteString(`\n`)
+		case r == '\t':
+			out.WriteString(`\t`)
+		case r == '$' && strings.HasPrefix(s[i+1:], "{"):
+			out.WriteString(`\$`)
+		case r < ' ' || r == 0x7f:
+			fmt.Fprintf(&out, `\u%04x`, r)
+		default:
+			out.WriteString(s[i : i+size]) // invalid UTF-8 is kept as is
+		}
+		i += size
+	}
+	return out.String()
+}
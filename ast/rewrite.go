@@ -0,0 +1,315 @@
+package ast
+
+// Rewrite returns a copy of node with fn applied to every node, children
+// before their parent, so fn sees the children already rewritten. Every node
+// includes the identifiers that bind or name something, such as let names,
+// parameters, properties and struct fields, and a class's operator methods.
+// The input tree is left untouched, so passes such as macro expansion and
+// desugaring can run on the same tree any number of times. fn returns its
+// argument to keep a node. A child whose replacement has the wrong type for
+// its field, e.g. a non-block where a block is required, is kept.
+func Rewrite(node Node, fn func(Node) Node) Node {
+	switch n := node.(type) {
+	case nil:
+		return nil
+	case *Program:
+		c := *n
+		c.Statements = rewriteStatements(n.Statements, fn)
+		return fn(&c)
+	case *BlockStatement:
+		c := *n
+		c.Statements = rewriteStatements(n.Statements, fn)
+		return fn(&c)
+	case *ExpressionStatement:
+		c := *n
+		c.Expression = rewriteExpression(n.Expression, fn)
+		return fn(&c)
+	case *LetStatement:
+		c := *n
+		if len(n.Names) > 0 { // Name is Names[0], visited once
+			c.Names = rewriteIdentifiers(n.Names, fn)
+			c.Name = c.Names[0]
+		} else {
+			c.Name = rewriteIdentifier(n.Name, fn)
+		}
+		c.Value = rewriteExpression(n.Value, fn)
+		return fn(&c)
+	case *ReturnStatement:
+		c := *n
+		c.ReturnValue = rewriteExpression(n.ReturnValue, fn)
+		return fn(&c)
+	case *YieldStatement:
+		c := *n
+		c.Value = rewriteExpression(n.Value, fn)
+		return fn(&c)
+	case *AssertStatement:
+		c := *n
+		c.Condition = rewriteExpression(n.Condition, fn)
+		c.Message = rewriteExpression(n.Message, fn)
+		return fn(&c)
+	case *FunctionStatement:
+		c := *n
+		c.Name = rewriteIdentifier(n.Name, fn)
+		c.Function = rewriteFunction(n.Function, fn)
+		return fn(&c)
+	case *OperatorMethod:
+		c := *n
+		c.Function = rewriteFunction(n.Function, fn)
+		return fn(&c)
+	case *ClassStatement:
+		c := *n
+		c.Name = rewriteIdentifier(n.Name, fn)
+		c.Methods = make([]*FunctionStatement, len(n.Methods))
+		for i, m := range n.Methods {
+			c.Methods[i] = m
+			if r, ok := Rewrite(m, fn).(*FunctionStatement); ok {
+				c.Methods[i] = r
+			}
+		}
+		c.Operators = make([]*OperatorMethod, len(n.Operators))
+		for i, m := range n.Operators {
+			c.Operators[i] = m
+			if r, ok := Rewrite(m, fn).(*OperatorMethod); ok {
+				c.Operators[i] = r
+			}
+		}
+		return fn(&c)
+	case *StructStatement:
+		c := *n
+		c.Name = rewriteIdentifier(n.Name, fn)
+		c.Fields = rewriteIdentifiers(n.Fields, fn)
+		return fn(&c)
+	case *ImportStatement:
+		c := *n
+		if n.Path != nil {
+			if r, ok := Rewrite(n.Path, fn).(*StringLiteral); ok {
+				c.Path = r
+			}
+		}
+		c.Alias = rewriteIdentifier(n.Alias, fn)
+		return fn(&c)
+	case *WhileStatement:
+		c := *n
+		c.Condition = rewriteExpression(n.Condition, fn)
+		c.Body = rewriteBlock(n.Body, fn)
+		return fn(&c)
+	case *DoWhileStatement:
+		c := *n
+		c.Body = rewriteBlock(n.Body, fn)
+		c.Condition = rewriteExpression(n.Condition, fn)
+		return fn(&c)
+	case *ForStatement:
+		c := *n
+		c.Init = rewriteStatement(n.Init, fn)
+		c.Condition = rewriteExpression(n.Condition, fn)
+		c.Post = rewriteStatement(n.Post, fn)
+		c.Body = rewriteBlock(n.Body, fn)
+		return fn(&c)
+	case *ForInStatement:
+		c := *n
+		c.Binding = rewriteIdentifier(n.Binding, fn)
+		c.Iterable = rewriteExpression(n.Iterable, fn)
+		c.Body = rewriteBlock(n.Body, fn)
+		return fn(&c)
+	case *PrefixExpression:
+		c := *n
+		c.Right = rewriteExpression(n.Right, fn)
+		return fn(&c)
+	case *InfixExpression:
+		c := *n
+		c.Left = rewriteExpression(n.Left, fn)
+		c.Right = rewriteExpression(n.Right, fn)
+		return fn(&c)
+	case *IfExpression:
+		c := *n
+		c.Condition = rewriteExpression(n.Condition, fn)
+		c.Consequence = rewriteBlock(n.Consequence, fn)
+		c.Alternative = rewriteBlock(n.Alternative, fn)
+		return fn(&c)
+	case *FunctionLiteral:
+		c := *n
+		c.Parameters = rewriteIdentifiers(n.Parameters, fn)
+		c.Body = rewriteBlock(n.Body, fn)
+		return fn(&c)
+	case *MacroLiteral:
+		c := *n
+		c.Parameters = rewriteIdentifiers(n.Parameters, fn)
+		c.Body = rewriteBlock(n.Body, fn)
+		return fn(&c)
+	case *CallExpression:
+		c := *n
+		c.Function = rewriteExpression(n.Function, fn)
+		c.Arguments = rewriteExpressions(n.Arguments, fn)
+		return fn(&c)
+	case *NamedArgument:
+		c := *n
+		c.Name = rewriteIdentifier(n.Name, fn)
+		c.Value = rewriteExpression(n.Value, fn)
+		return fn(&c)
+	case *TupleLiteral:
+		c := *n
+		c.Elements = rewriteExpressions(n.Elements, fn)
+		return fn(&c)
+	case *TupleIndexExpression:
+		c := *n
+		c.Tuple = rewriteExpression(n.Tuple, fn)
+		if n.Index != nil {
+			if r, ok := Rewrite(n.Index, fn).(*IntegerLiteral); ok {
+				c.Index = r
+			}
+		}
+		return fn(&c)
+	case *MemberExpression:
+		c := *n
+		c.Object = rewriteExpression(n.Object, fn)
+		c.Property = rewriteIdentifier(n.Property, fn)
+		return fn(&c)
+	case *IndexExpression:
+		c := *n
+		c.Left = rewriteExpression(n.Left, fn)
+		c.Index = rewriteExpression(n.Index, fn)
+		return fn(&c)
+	case *SliceExpression:
+		c := *n
+		c.Left = rewriteExpression(n.Left, fn)
+		c.Low = rewriteExpression(n.Low, fn)
+		c.High = rewriteExpression(n.High, fn)
+		return fn(&c)
+	case *PipeExpression:
+		c := *n
+		c.Value = rewriteExpression(n.Value, fn)
+		c.Function = rewriteExpression(n.Function, fn)
+		return fn(&c)
+	case *InterpolatedString:
+		c := *n
+		c.Parts = rewriteExpressions(n.Parts, fn)
+		return fn(&c)
+	case *HashLiteral:
+		c := *n
+		c.Pairs = make([]HashPair, len(n.Pairs))
+		for i, pair := range n.Pairs {
+			c.Pairs[i] = HashPair{Key: rewriteExpression(pair.Key, fn), Value: rewriteExpression(pair.Value, fn)}
+		}
+		return fn(&c)
+	case *StructLiteral:
+		c := *n
+		c.Type = rewriteIdentifier(n.Type, fn)
+		c.Fields = make([]StructField, len(n.Fields))
+		for i, field := range n.Fields {
+			c.Fields[i] = StructField{Name: rewriteIdentifier(field.Name, fn), Value: rewriteExpression(field.Value, fn)}
+		}
+		return fn(&c)
+	case *CompoundAssign:
+		c := *n
+		c.Target = rewriteExpression(n.Target, fn)
+		c.Value = rewriteExpression(n.Value, fn)
+		return fn(&c)
+	case *TernaryExpression:
+		c := *n
+		c.Condition = rewriteExpression(n.Condition, fn)
+		c.Consequence = rewriteExpression(n.Consequence, fn)
+		c.Alternative = rewriteExpression(n.Alternative, fn)
+		return fn(&c)
+	case *RangeExpression:
+		c := *n
+		c.Start = rewriteExpression(n.Start, fn)
+		c.Stop = rewriteExpression(n.Stop, fn)
+		c.Step = rewriteExpression(n.Step, fn)
+		return fn(&c)
+	case *QuoteExpression:
+		c := *n
+		c.Node = rewriteExpression(n.Node, fn)
+		return fn(&c)
+	case *UnquoteExpression:
+		c := *n
+		c.Node = rewriteExpression(n.Node, fn)
+		return fn(&c)
+	}
+	// leaves: identifiers and literals
+	return fn(node)
+}
+
+func rewriteStatement(s Statement, fn func(Node) Node) Statement {
+	if s == nil {
+		return nil
+	}
+	if r, ok := Rewrite(s, fn).(Statement); ok {
+		return r
+	}
+	return s
+}
+
+func rewriteStatements(list []Statement, fn func(Node) Node) []Statement {
+	if list == nil {
+		return nil
+	}
+	out := make([]Statement, len(list))
+	for i, s := range list {
+		out[i] = rewriteStatement(s, fn)
+	}
+	return out
+}
+
+func rewriteExpression(e Expression, fn func(Node) Node) Expression {
+	if e == nil {
+		return nil
+	}
+	if r, ok := Rewrite(e, fn).(Expression); ok {
+		return r
+	}
+	return e
+}
+
+func rewriteExpressions(list []Expression, fn func(Node) Node) []Expression {
+	if list == nil {
+		return nil
+	}
+	out := make([]Expression, len(list))
+	for i, e := range list {
+		out[i] = rewriteExpression(e, fn)
+	}
+	return out
+}
+
+// rewriteIdentifier rewrites a name, keeping it if fn replaces it with
+// anything but an identifier.
+func rewriteIdentifier(ident *Identifier, fn func(Node) Node) *Identifier {
+	if ident == nil {
+		return nil
+	}
+	if r, ok := Rewrite(ident, fn).(*Identifier); ok {
+		return r
+	}
+	return ident
+}
+
+func rewriteIdentifiers(list []*Identifier, fn func(Node) Node) []*Identifier {
+	if list == nil {
+		return nil
+	}
+	out := make([]*Identifier, len(list))
+	for i, ident := range list {
+		out[i] = rewriteIdentifier(ident, fn)
+	}
+	return out
+}
+
+func rewriteBlock(b *BlockStatement, fn func(Node) Node) *BlockStatement {
+	if b == nil {
+		return nil
+	}
+	if r, ok := Rewrite(b, fn).(*BlockStatement); ok {
+		return r
+	}
+	return b
+}
+
+func rewriteFunction(f *FunctionLiteral, fn func(Node) Node) *FunctionLiteral {
+	if f == nil {
+		return nil
+	}
+	if r, ok := Rewrite(f, fn).(*FunctionLiteral); ok {
+		return r
+	}
+	return f
+}
@@ -0,0 +1,21 @@
+package ast
+
+import (
+	"bytes"
+	"io"
+)
+
+// Write renders node to w in the same form as node.String(). The whole tree
+// is rendered into a single buffer, so dumping large programs stays linear.
+func Write(w io.Writer, node Node) (int, error) {
+	var out bytes.Buffer
+	node.write(&out)
+	return w.Write(out.Bytes())
+}
+
+// render returns node rendered into a fresh buffer.
+func render(node Node) string {
+	var out bytes.Buffer
+	node.write(&out)
+	return out.String()
+}
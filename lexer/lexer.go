@@ -1,22 +1,113 @@
 package lexer
 
-import "interpreter/token"
+import (
+	"fmt"
+	"interpreter/token"
+	"io"
+	"slices"
+	"strings"
+)
 
 type Lexer struct {
 	input        string
 	position     int  // current position in input (current character)
 	readPosition int  // current reading position in input (after current character)
 	ch           byte // current character under examination
+	names        *token.Interner
+	errors       []*Error
+	keepComments bool // emit COMMENT tokens instead of skipping comments
+
+	// operators added with WithOperator, longest first
+	operators []operator
+
+	// src holds the rest of the input of a lexer created with NewFromReader,
+	// nil once it has all been read into input, see more.
+	src io.Reader
+	buf []byte // the input read from src, see more
+
+	// interps holds, for each string interpolation being scanned, the number
+	// of "{" opened inside it, so the "}" that closes it can be told apart.
+	interps []int
+
+	// last is the token NextToken returned last. After a "/", "/=" or "<<"
+	// the state is saved in rescan, for ScanRegex and ScanHeredoc to undo the
+	// scanning of what followed it.
+	last   token.Token
+	rescan *rescanState
+
+	// line is the line of offset counted, which starts at lineOffset, so a
+	// position is found by counting only the newlines since the last one.
+	line       int
+	lineOffset int
+	counted    int
+}
+
+// rescanState is the part of the lexer state that scanning a token may change
+// besides the position, saved after the token at offset.
+type rescanState struct {
+	offset  int
+	interps []int
+	errors  int
+}
+
+// Error is a problem found while scanning, such as an invalid escape
+// sequence. The token containing it is still returned.
+type Error struct {
+	Offset int // byte offset of the problem in the input
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *Error) Error() string { return e.Msg }
+
+// Option configures a Lexer.
+type Option func(*Lexer)
+
+// WithComments makes the lexer return comments as COMMENT tokens, delimiters
+// included, for tools that need to keep them. By default they are skipped.
+func WithComments() Option {
+	return func(l *Lexer) {
+		l.keepComments = true
+	}
+}
+
+// operator is a spelling added with WithOperator and the type it scans as.
+type operator struct {
+	spelling string
+	t        token.TokenType
+}
+
+// WithOperator makes the lexer scan spelling as a token of type t, which
+// should be token.CUSTOM or above. Added operators are tried before the
+// built-in ones, longest first, so "<>" can be added even though "<" is an
+// operator already. Together with Parser.RegisterInfix or RegisterPrefix this
+// adds an operator to the language.
+func WithOperator(spelling string, t token.TokenType) Option {
+	return func(l *Lexer) {
+		if spelling == "" {
+			return
+		}
+		i := 0
+		for i < len(l.operators) && len(l.operators[i].spelling) >= len(spelling) {
+			i++
+		}
+		l.operators = slices.Insert(l.operators, i, operator{spelling, t})
+	}
 }
 
-func New(input string) *Lexer {
-	l := &Lexer{input: input}
+func New(input string, opts ...Option) *Lexer {
+	l := &Lexer{input: input, names: token.NewInterner(), line: 1}
+	for _, opt := range opts {
+		opt(l)
+	}
 	l.readChar()
+	l.skipShebang()
 	return l
 }
 
 func (l *Lexer) readChar() {
-	if l.readPosition >= len(l.input) {
+	if !l.available(l.readPosition + 1) {
 		l.ch = 0
 	} else {
 		l.ch = l.input[l.readPosition]
@@ -26,76 +117,219 @@ func (l *Lexer) readChar() {
 	l.readPosition += 1
 }
 
+// NextToken scans the next token. Token literals are slices of the input,
+// so lexing does not copy the source text.
 func (l *Lexer) NextToken() token.Token {
+	switch l.last.Type {
+	case token.SLASH, token.SLASH_ASSIGN, token.SHL:
+		l.rescan = &rescanState{offset: l.last.Offset, interps: slices.Clone(l.interps), errors: len(l.errors)}
+	}
+	from := l.position
+	tok := l.scan()
+	tok.LineStart = strings.IndexByte(l.input[from:tok.Offset], '\n') >= 0
+	l.locate(&tok)
+	l.last = tok
+	return tok
+}
+
+// restore undoes what scanning past the token at offset did to the lexer
+// state, since the input from offset is being scanned again.
+func (l *Lexer) restore(offset int) {
+	if s := l.rescan; s != nil && s.offset == offset {
+		l.interps = s.interps
+		l.errors = l.errors[:s.errors]
+	}
+	l.rescan = nil
+}
+
+// locate sets the line and column of tok.
+func (l *Lexer) locate(tok *token.Token) {
+	pos := l.positionOf(tok.Offset)
+	tok.Line, tok.Column = pos.Line, pos.Column
+}
+
+// positionOf returns the line and column of offset. Tokens are located in
+// order, so this usually only counts the newlines since the previous token;
+// offsets before it, as when rescanning, count back from there.
+func (l *Lexer) positionOf(offset int) token.Position {
+	if offset >= l.counted {
+		skipped := l.input[l.counted:offset]
+		if n := strings.Count(skipped, "\n"); n > 0 {
+			l.line += n
+			l.lineOffset = l.counted + strings.LastIndexByte(skipped, '\n') + 1
+		}
+	} else {
+		l.line -= strings.Count(l.input[offset:l.counted], "\n")
+		l.lineOffset = strings.LastIndexByte(l.input[:offset], '\n') + 1
+	}
+	l.counted = offset
+	return token.Position{Offset: offset, Line: l.line, Column: offset - l.lineOffset + 1}
+}
+
+// scan skips whitespace and comments and scans the token after them.
+func (l *Lexer) scan() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
+	start := l.position
+
+	for _, op := range l.operators {
+		if l.available(start+len(op.spelling)) && strings.HasPrefix(l.input[start:], op.spelling) {
+			l.readPosition = start + len(op.spelling)
+			l.readChar()
+			return l.newToken(op.t, start)
+		}
+	}
 
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
-			ch := l.ch
 			l.readChar()
-			literal := string(ch) + string(l.ch)
-			tok = token.Token{Type: token.EQ, Literal: literal}
+			tok.Type = token.EQ
 		} else {
-			tok = newToken(token.ASSIGN, l.ch)
+			tok.Type = token.ASSIGN
 		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		tok.Type = l.withAssign(token.MINUS, token.MINUS_ASSIGN)
 	case '!':
 		if l.peekChar() == '=' {
-			ch := l.ch
 			l.readChar()
-			literal := string(ch) + string(l.ch)
-			tok = token.Token{Type: token.NOT_EQ, Literal: literal}
+			tok.Type = token.NOT_EQ
 		} else {
-			tok = newToken(token.BANG, l.ch)
+			tok.Type = token.BANG
 		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		// only reached for comments when they are kept
+		switch l.peekChar() {
+		case '/':
+			l.skipLine()
+			return l.newToken(token.COMMENT, start)
+		case '*':
+			l.skipBlockComment()
+			return l.newToken(token.COMMENT, start)
+		}
+		tok.Type = l.withAssign(token.SLASH, token.SLASH_ASSIGN)
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		if l.peekChar() == '*' {
+			l.readChar()
+			tok.Type = token.POWER
+		} else {
+			tok.Type = l.withAssign(token.ASTERISK, token.ASTERISK_ASSIGN)
+		}
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		tok.Type = l.pair('<', token.SHL, token.LT)
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		tok.Type = l.pair('>', token.SHR, token.GT)
+	case '&':
+		tok.Type = l.pair('&', token.AND, token.BIT_AND)
+	case '|':
+		tok.Type = l.pair('|', token.OR, token.BIT_OR)
+		if tok.Type == token.BIT_OR {
+			tok.Type = l.pair('>', token.PIPE, token.BIT_OR)
+		}
+	case '^':
+		tok.Type = token.BIT_XOR
+	case '~':
+		tok.Type = token.BIT_NOT
 	case ';':
-		tok = newToken(token.SEMICOLON, l.ch)
+		tok.Type = token.SEMICOLON
 	case '(':
-		tok = newToken(token.LPAREN, l.ch)
+		tok.Type = token.LPAREN
 	case ')':
-		tok = newToken(token.RPAREN, l.ch)
+		tok.Type = token.RPAREN
+	case '[':
+		tok.Type = token.LBRACKET
+	case ']':
+		tok.Type = token.RBRACKET
 	case ',':
-		tok = newToken(token.COMMA, l.ch)
+		tok.Type = token.COMMA
+	case ':':
+		tok.Type = token.COLON
+	case '?':
+		tok.Type = token.QUESTION
+	case '.':
+		tok.Type = l.pair('.', token.DOTDOT, token.DOT)
+		if tok.Type == token.DOTDOT {
+			tok.Type = l.pair('.', token.ELLIPSIS, token.DOTDOT)
+		}
+	case '"':
+		return l.readString(false)
+	case '`':
+		return l.readRawString()
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok.Type = l.withAssign(token.PLUS, token.PLUS_ASSIGN)
 	case '{':
-		tok = newToken(token.LBRACE, l.ch)
+		if n := len(l.interps); n > 0 {
+			l.interps[n-1]++
+		}
+		tok.Type = token.LBRACE
 	case '}':
-		tok = newToken(token.RBRACE, l.ch)
+		if n := len(l.interps); n > 0 {
+			if l.interps[n-1] == 0 {
+				l.interps = l.interps[:n-1]
+				return l.readString(true)
+			}
+			l.interps[n-1]--
+		}
+		tok.Type = token.RBRACE
 	case 0:
-		tok.Literal = ""
-		tok.Type = token.EOF
+		// stay put so repeated calls keep returning EOF at the end offset
+		return l.newToken(token.EOF, start)
 	default:
 		if isLetter(l.ch) {
-			tok.Literal = l.readIdentifier()
+			l.readIdentifier()
+			tok = l.newToken(token.IDENT, start)
 			tok.Type = token.LookupIdent(tok.Literal)
+			if tok.Type == token.IDENT {
+				tok.Literal, _ = l.names.Intern(tok.Literal)
+			}
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
-			return tok
+			l.readNumber()
+			return l.newToken(token.INT, start)
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch)
+			tok.Type = token.ILLEGAL
 		}
 	}
 
 	l.readChar()
-	return tok
+	return l.newToken(tok.Type, start)
+}
+
+// Names returns the table of identifier names seen so far. Every IDENT token
+// literal is the interned copy from this table.
+func (l *Lexer) Names() *token.Interner {
+	return l.names
+}
+
+// Errors returns the errors found so far, in input order.
+func (l *Lexer) Errors() []*Error {
+	return l.errors
+}
+
+func (l *Lexer) addError(offset int, format string, args ...any) {
+	pos := l.positionOf(offset)
+	l.errors = append(l.errors, &Error{Offset: offset, Line: pos.Line, Column: pos.Column, Msg: fmt.Sprintf(format, args...)})
 }
 
 // Lexer methods
+
+// pair returns double when the current character is followed by second, as
+// in "&&", consuming it. Otherwise it returns single.
+func (l *Lexer) pair(second byte, double, single token.TokenType) token.TokenType {
+	if l.peekChar() == second {
+		l.readChar()
+		return double
+	}
+	return single
+}
+
+// withAssign returns compound when the operator is followed by '=', as in
+// "+=", consuming the '='. Otherwise it returns simple.
+func (l *Lexer) withAssign(simple, compound token.TokenType) token.TokenType {
+	return l.pair('=', compound, simple)
+}
+
 func (l *Lexer) readIdentifier() string {
 	position := l.position
 	for isLetter(l.ch) {
@@ -112,25 +346,79 @@ func (l *Lexer) readNumber() string {
 	return l.input[position:l.position]
 }
 
+// skipWhitespace skips whitespace and, unless they are kept, // comments
+// running to the end of the line and nestable /* */ comments.
 func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
+	for {
+		switch {
+		case l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r':
+			l.readChar()
+		case l.ch == '/' && l.peekChar() == '/' && !l.keepComments:
+			l.skipLine()
+		case l.ch == '/' && l.peekChar() == '*' && !l.keepComments:
+			l.skipBlockComment()
+		default:
+			return
+		}
+	}
+}
+
+// skipBlockComment skips a /* */ comment starting at the current character.
+// Comments nest, so /* a /* b */ c */ is one comment. An unterminated comment
+// is reported and runs to the end of input.
+func (l *Lexer) skipBlockComment() {
+	start := l.position
+	depth := 0
+	for l.ch != 0 {
+		switch {
+		case l.ch == '/' && l.peekChar() == '*':
+			depth++
+			l.readChar()
+		case l.ch == '*' && l.peekChar() == '/':
+			depth--
+			l.readChar()
+		}
+		l.readChar()
+		if depth == 0 {
+			return
+		}
+	}
+	l.addError(start, "unterminated block comment")
+}
+
+// skipLine advances to the next newline or the end of input.
+func (l *Lexer) skipLine() {
+	for l.ch != '\n' && l.ch != 0 {
 		l.readChar()
 	}
 }
 
+// skipShebang skips a leading "#!" interpreter line so scripts can be made
+// executable, e.g. "#!/usr/bin/env monkey".
+func (l *Lexer) skipShebang() {
+	if l.ch != '#' || l.peekChar() != '!' {
+		return
+	}
+	l.skipLine()
+}
+
 func (l *Lexer) peekChar() byte {
-	if l.readPosition >= len(l.input) {
+	if !l.available(l.readPosition + 1) {
 		return 0
 	} else {
 		return l.input[l.readPosition]
 	}
 }
 
-// Helpers
-func newToken(tokenType token.TokenType, ch byte) token.Token {
-	return token.Token{Type: tokenType, Literal: string(ch)}
+// newToken returns a token of type t spanning the input from start up to the
+// current position.
+func (l *Lexer) newToken(t token.TokenType, start int) token.Token {
+	end := min(l.position, len(l.input))
+	return token.Token{Type: t, Literal: l.input[start:end], Offset: start, End: end}
 }
 
+// Helpers
+
 func isLetter(ch byte) bool {
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
 }
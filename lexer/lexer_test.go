@@ -1,7 +1,16 @@
 package lexer
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
+	"unsafe"
 
 	"interpreter/token"
 )
@@ -15,7 +24,7 @@ let add = fn(x, y) {
 };
 
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -122,3 +131,768 @@ if (5 < 10) {
 	}
 
 }
+
+func TestShebang(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []token.TokenType
+	}{
+		{"#!/usr/bin/env monkey\nlet x = 5;", []token.TokenType{token.LET, token.IDENT, token.ASSIGN, token.INT, token.SEMICOLON, token.EOF}},
+		{"#!/usr/bin/env monkey", []token.TokenType{token.EOF}},
+		{"x #!", []token.TokenType{token.IDENT, token.ILLEGAL, token.BANG, token.EOF}},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		for j, expected := range tt.expected {
+			tok := l.NextToken()
+			if tok.Type != expected {
+				t.Fatalf("tests[%d][%d] - tokenType wrong. Expected %q, got %q", i, j, expected, tok.Type)
+			}
+		}
+	}
+}
+
+func TestIdentifierInterning(t *testing.T) {
+	l := New("let foo = bar + foo; foo")
+
+	var foos []string
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		if tok.Literal == "foo" {
+			foos = append(foos, tok.Literal)
+		}
+	}
+	if len(foos) != 3 {
+		t.Fatalf("expected 3 foo tokens, got %d", len(foos))
+	}
+	for i, foo := range foos {
+		if unsafe.StringData(foo) != unsafe.StringData(foos[0]) {
+			t.Errorf("foos[%d] is not the interned string", i)
+		}
+	}
+
+	names := l.Names()
+	if names.Len() != 2 {
+		t.Fatalf("names.Len() = %d, want 2", names.Len())
+	}
+	for i, want := range []string{"foo", "bar"} {
+		if got := names.Name(i); got != want {
+			t.Errorf("names.Name(%d) = %q, want %q", i, got, want)
+		}
+		if idx, ok := names.Lookup(want); !ok || idx != i {
+			t.Errorf("names.Lookup(%q) = %d, %t, want %d, true", want, idx, ok, i)
+		}
+	}
+}
+
+// benchFile is a script of the benchmark corpus, testdata/*.monkey at the
+// module root, which the parser benchmarks share.
+type benchFile struct {
+	name, src string
+}
+
+func benchCorpus(b *testing.B) []benchFile {
+	paths, err := filepath.Glob("../testdata/*.monkey")
+	if err != nil || len(paths) == 0 {
+		b.Fatalf("no benchmark corpus: %v", err)
+	}
+	files := make([]benchFile, len(paths))
+	for i, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		files[i] = benchFile{filepath.Base(path), string(src)}
+	}
+	return files
+}
+
+func benchmarkLexer(b *testing.B, input string) {
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l := New(input)
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		}
+	}
+}
+
+// BenchmarkLexer lexes each corpus file, once as written and once repeated
+// to some ten thousand lines.
+func BenchmarkLexer(b *testing.B) {
+	for _, file := range benchCorpus(b) {
+		b.Run(file.name, func(b *testing.B) { benchmarkLexer(b, file.src) })
+		b.Run(file.name+"/large", func(b *testing.B) { benchmarkLexer(b, largeScript(file.src)) })
+	}
+}
+
+// largeScript repeats src to roughly 10k lines.
+func largeScript(src string) string {
+	return strings.Repeat(src, max(1, 10000/(strings.Count(src, "\n")+1)))
+}
+
+func TestTokenSpans(t *testing.T) {
+	input := "let x == 10;"
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedOffset int
+		expectedEnd    int
+	}{
+		{token.LET, 0, 3},
+		{token.IDENT, 4, 5},
+		{token.EQ, 6, 8},
+		{token.INT, 9, 11},
+		{token.SEMICOLON, 11, 12},
+		{token.EOF, 12, 12},
+		{token.EOF, 12, 12},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokenType wrong. Expected %q, got %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Offset != tt.expectedOffset || tok.End != tt.expectedEnd {
+			t.Errorf("tests[%d] - span wrong. Expected [%d:%d], got [%d:%d]", i, tt.expectedOffset, tt.expectedEnd, tok.Offset, tok.End)
+		}
+		if tok.Literal != input[tok.Offset:tok.End] {
+			t.Errorf("tests[%d] - literal %q does not match its span", i, tok.Literal)
+		}
+	}
+}
+
+func TestStringsAndColons(t *testing.T) {
+	input := `"foobar" "foo bar" {"a": 1} "unterminated`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.STRING, "foobar"},
+		{token.STRING, "foo bar"},
+		{token.LBRACE, "{"},
+		{token.STRING, "a"},
+		{token.COLON, ":"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.ILLEGAL, `"unterminated`},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokenType wrong. Expected %q, got %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. Expected %q, got %q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+	errors := l.Errors()
+	if len(errors) != 1 || errors[0].Msg != "unterminated string" {
+		t.Errorf("wrong errors: %v", errors)
+	}
+}
+
+func TestLoopKeywords(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected token.TokenType
+	}{
+		{"while", token.WHILE},
+		{"whilex", token.IDENT},
+		{"do", token.DO},
+		{"done", token.IDENT},
+		{"for", token.FOR},
+		{"format", token.IDENT},
+		{"in", token.IN},
+		{"index", token.IDENT},
+		{"null", token.NULL},
+		{"nullable", token.IDENT},
+		{"import", token.IMPORT},
+		{"as", token.IDENT},
+		{"struct", token.STRUCT},
+		{"yield", token.YIELD},
+		{"class", token.CLASS},
+		{"assert", token.ASSERT},
+		{"quote", token.QUOTE},
+		{"unquote", token.UNQUOTE},
+	}
+
+	for i, tt := range tests {
+		tok := New(tt.input).NextToken()
+		if tok.Type != tt.expected {
+			t.Errorf("tests[%d] - tokenType wrong. Expected %q, got %q", i, tt.expected, tok.Type)
+		}
+	}
+}
+
+func TestCompoundAssignOperators(t *testing.T) {
+	input := "+= -= *= /= + - * /"
+	expected := []token.TokenType{
+		token.PLUS_ASSIGN, token.MINUS_ASSIGN, token.ASTERISK_ASSIGN, token.SLASH_ASSIGN,
+		token.PLUS, token.MINUS, token.ASTERISK, token.SLASH,
+	}
+
+	l := New(input)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt {
+			t.Fatalf("tests[%d] - tokenType wrong. Expected %q, got %q", i, tt, tok.Type)
+		}
+		if tok.Literal != tt.String() {
+			t.Fatalf("tests[%d] - literal wrong. Expected %q, got %q", i, tt.String(), tok.Literal)
+		}
+	}
+}
+
+func TestLogicalAndBitwiseOperators(t *testing.T) {
+	input := "a && b || c & d | e ^ ~f << g >> h < i > j 1..10 k... l.m |> 2**3 [ ]"
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.AND, "&&"},
+		{token.IDENT, "b"},
+		{token.OR, "||"},
+		{token.IDENT, "c"},
+		{token.BIT_AND, "&"},
+		{token.IDENT, "d"},
+		{token.BIT_OR, "|"},
+		{token.IDENT, "e"},
+		{token.BIT_XOR, "^"},
+		{token.BIT_NOT, "~"},
+		{token.IDENT, "f"},
+		{token.SHL, "<<"},
+		{token.IDENT, "g"},
+		{token.SHR, ">>"},
+		{token.IDENT, "h"},
+		{token.LT, "<"},
+		{token.IDENT, "i"},
+		{token.GT, ">"},
+		{token.IDENT, "j"},
+		{token.INT, "1"},
+		{token.DOTDOT, ".."},
+		{token.INT, "10"},
+		{token.IDENT, "k"},
+		{token.ELLIPSIS, "..."},
+		{token.IDENT, "l"},
+		{token.DOT, "."},
+		{token.IDENT, "m"},
+		{token.PIPE, "|>"},
+		{token.INT, "2"},
+		{token.POWER, "**"},
+		{token.INT, "3"},
+		{token.LBRACKET, "["},
+		{token.RBRACKET, "]"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokenType wrong. Expected %q, got %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. Expected %q, got %q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedLiteral string
+		expectedErrors  []string
+	}{
+		{`"plain"`, "plain", nil},
+		{`"a\nb\tc"`, "a\nb\tc", nil},
+		{`"say \"hi\""`, `say "hi"`, nil},
+		{`"back\\slash"`, `back\slash`, nil},
+		{`"été"`, "été", nil},
+		{`"cost \${x}"`, "cost ${x}", nil},
+		{`"\q"`, `\q`, []string{`invalid escape sequence "\\q" in string`}},
+		{`"\u12"`, `\u12`, []string{`invalid escape sequence "\\u12" in string`}},
+		{`"\uZZZZ!"`, `\uZZZZ!`, []string{`invalid escape sequence "\\uZZZZ" in string`}},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+		if tok.Type != token.STRING {
+			t.Fatalf("tests[%d] - tokenType wrong. Expected %q, got %q", i, token.STRING, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - literal wrong. Expected %q, got %q", i, tt.expectedLiteral, tok.Literal)
+		}
+		errors := l.Errors()
+		if len(errors) != len(tt.expectedErrors) {
+			t.Fatalf("tests[%d] - expected %d errors, got %d", i, len(tt.expectedErrors), len(errors))
+		}
+		for j, msg := range tt.expectedErrors {
+			if errors[j].Msg != msg {
+				t.Errorf("tests[%d] - error %d wrong. Expected %q, got %q", i, j, msg, errors[j].Msg)
+			}
+			if errors[j].Offset != 1 {
+				t.Errorf("tests[%d] - error %d offset wrong. Expected 1, got %d", i, j, errors[j].Offset)
+			}
+		}
+	}
+}
+
+func TestLineComments(t *testing.T) {
+	input := `// leading comment
+let x = 5; // trailing comment
+let y = x + // mid-expression
+	10 / 2;
+// comment at end of file without newline`
+	expected := []token.TokenType{
+		token.LET, token.IDENT, token.ASSIGN, token.INT, token.SEMICOLON,
+		token.LET, token.IDENT, token.ASSIGN, token.IDENT, token.PLUS,
+		token.INT, token.SLASH, token.INT, token.SEMICOLON,
+		token.EOF,
+	}
+
+	l := New(input)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Type != tt {
+			t.Fatalf("tests[%d] - tokenType wrong. Expected %q, got %q", i, tt, tok.Type)
+		}
+	}
+}
+
+func TestBlockComments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []token.TokenType
+		errors   []string
+	}{
+		{"a /* comment */ b", []token.TokenType{token.IDENT, token.IDENT, token.EOF}, nil},
+		{"a /* outer /* inner */ still outer */ b", []token.TokenType{token.IDENT, token.IDENT, token.EOF}, nil},
+		{"/* multi\nline */ 5 */ 2", []token.TokenType{token.INT, token.ASTERISK, token.SLASH, token.INT, token.EOF}, nil},
+		{"a /**/ b", []token.TokenType{token.IDENT, token.IDENT, token.EOF}, nil},
+		{"a /* never /* closed */", []token.TokenType{token.IDENT, token.EOF}, []string{"unterminated block comment"}},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		for j, expected := range tt.expected {
+			tok := l.NextToken()
+			if tok.Type != expected {
+				t.Fatalf("tests[%d][%d] - tokenType wrong. Expected %q, got %q", i, j, expected, tok.Type)
+			}
+		}
+		if len(l.Errors()) != len(tt.errors) {
+			t.Fatalf("tests[%d] - expected %d errors, got %d", i, len(tt.errors), len(l.Errors()))
+		}
+		for j, msg := range tt.errors {
+			if l.Errors()[j].Msg != msg {
+				t.Errorf("tests[%d] - error wrong. Expected %q, got %q", i, msg, l.Errors()[j].Msg)
+			}
+		}
+	}
+}
+
+func TestKeepComments(t *testing.T) {
+	input := "x /* a /* b */ */ + // tail\ny"
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "x"},
+		{token.COMMENT, "/* a /* b */ */"},
+		{token.PLUS, "+"},
+		{token.COMMENT, "// tail"},
+		{token.IDENT, "y"},
+		{token.EOF, ""},
+	}
+
+	l := New(input, WithComments())
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokenType wrong. Expected %q, got %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. Expected %q, got %q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestCustomOperators(t *testing.T) {
+	const (
+		MODMOD = token.CUSTOM + iota
+		DIAMOND
+		ARROW
+	)
+	input := "a %% b <> c < d <=> e"
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{MODMOD, "%%"},
+		{token.IDENT, "b"},
+		{DIAMOND, "<>"},
+		{token.IDENT, "c"},
+		{token.LT, "<"},
+		{token.IDENT, "d"},
+		{ARROW, "<=>"},
+		{token.IDENT, "e"},
+		{token.EOF, ""},
+	}
+
+	l := New(input, WithOperator("<>", DIAMOND), WithOperator("%%", MODMOD), WithOperator("<=>", ARROW))
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokenType wrong. Expected %q, got %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. Expected %q, got %q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestStringInterpolation(t *testing.T) {
+	input := `"hi ${name}, ${ {"a": 1} + f("${x}") }!" "${y}"`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.STRING_HEAD, "hi "},
+		{token.IDENT, "name"},
+		{token.STRING_MID, ", "},
+		{token.LBRACE, "{"},
+		{token.STRING, "a"},
+		{token.COLON, ":"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.PLUS, "+"},
+		{token.IDENT, "f"},
+		{token.LPAREN, "("},
+		{token.STRING_HEAD, ""},
+		{token.IDENT, "x"},
+		{token.STRING_TAIL, ""},
+		{token.RPAREN, ")"},
+		{token.STRING_TAIL, "!"},
+		{token.STRING_HEAD, ""},
+		{token.IDENT, "y"},
+		{token.STRING_TAIL, ""},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokenType wrong. Expected %q, got %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. Expected %q, got %q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestRawStrings(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{"`plain`", token.RAW_STRING, "plain"},
+		{"`C:\\dir\\n ${x} \"q\"`", token.RAW_STRING, `C:\dir\n ${x} "q"`},
+		{"`line one\nline two`", token.RAW_STRING, "line one\nline two"},
+		{"``", token.RAW_STRING, ""},
+		{"`unterminated", token.ILLEGAL, "`unterminated"},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokenType wrong. Expected %q, got %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - literal wrong. Expected %q, got %q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if want := tt.expectedType == token.ILLEGAL; want != (len(l.Errors()) == 1) {
+			t.Errorf("tests[%d] - wrong errors: %v", i, l.Errors())
+		}
+		if tok := l.NextToken(); tok.Type != token.EOF {
+			t.Errorf("tests[%d] - expected EOF, got %q", i, tok.Type)
+		}
+	}
+}
+
+func TestScanRegex(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+		next            token.TokenType
+	}{
+		{`/ab+c/;`, token.REGEX, `/ab+c/`, token.SEMICOLON},
+		{`/a\/b[/]c/gi)`, token.REGEX, `/a\/b[/]c/gi`, token.RPAREN},
+		{`/=/`, token.REGEX, `/=/`, token.EOF},
+		{"/abc\nx", token.ILLEGAL, "/abc", token.IDENT},
+		{`/abc`, token.ILLEGAL, "/abc", token.EOF},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		if tok := l.NextToken(); tok.Type != token.SLASH && tok.Type != token.SLASH_ASSIGN {
+			t.Fatalf("tests[%d] - first token is not a slash. got %q", i, tok.Type)
+		}
+		l.NextToken() // read past the slash, as the parser's lookahead does
+		tok := l.ScanRegex(0)
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokenType wrong. Expected %q, got %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - literal wrong. Expected %q, got %q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Type == token.ILLEGAL && len(l.Errors()) != 1 {
+			t.Errorf("tests[%d] - expected 1 error, got %d", i, len(l.Errors()))
+		}
+		if next := l.NextToken(); next.Type != tt.next {
+			t.Errorf("tests[%d] - next tokenType wrong. Expected %q, got %q", i, tt.next, next.Type)
+		}
+	}
+}
+
+func TestScanHeredoc(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+		next            token.TokenType
+	}{
+		{"<<EOF\nline one\n  line two\nEOF;", token.STRING, "line one\n  line two", token.SEMICOLON},
+		{"<<~SQL\n    select *\n      from t\n\n    SQL\n)", token.STRING, "select *\n  from t\n", token.RPAREN},
+		{"<<~E\n\n  \nE", token.STRING, "\n  ", token.EOF},
+		{"<<END\r\nwindows\r\nEND", token.STRING, "windows", token.EOF},
+		{"<<EOF\nEOF", token.STRING, "", token.EOF},
+		{"<<EOF\nno end\n", token.ILLEGAL, "<<EOF\nno end\n", token.EOF},
+		{"<< EOF\nx\nEOF", token.ILLEGAL, "<<", token.IDENT},
+		{"<<EOF x\nEOF", token.ILLEGAL, "<<EOF ", token.IDENT},
+		{"<<EOF\n  EOF is done\nEOF2\nEOF);", token.STRING, "  EOF is done\nEOF2", token.RPAREN},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		l.NextToken()
+		l.NextToken() // read past the <<, as the parser's lookahead does
+		tok := l.ScanHeredoc(0)
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokenType wrong. Expected %q, got %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - literal wrong. Expected %q, got %q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Type == token.ILLEGAL && len(l.Errors()) != 1 {
+			t.Errorf("tests[%d] - expected 1 error, got %d", i, len(l.Errors()))
+		}
+		if next := l.NextToken(); next.Type != tt.next {
+			t.Errorf("tests[%d] - next tokenType wrong. Expected %q, got %q", i, tt.next, next.Type)
+		}
+	}
+}
+
+func TestLineStart(t *testing.T) {
+	input := "let x = y\n(a) // c\n  - b /* multi\nline */ + c /* one line */ d"
+	expected := []struct {
+		literal   string
+		lineStart bool
+	}{
+		{"let", false},
+		{"x", false},
+		{"=", false},
+		{"y", false},
+		{"(", true},
+		{"a", false},
+		{")", false},
+		{"-", true},
+		{"b", false},
+		{"+", true},
+		{"c", false},
+		{"d", false},
+		{"", false},
+	}
+
+	l := New(input)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Literal != tt.literal {
+			t.Fatalf("tests[%d] - literal wrong. Expected %q, got %q", i, tt.literal, tok.Literal)
+		}
+		if tok.LineStart != tt.lineStart {
+			t.Errorf("tests[%d] - %q LineStart wrong. Expected %t, got %t", i, tok.Literal, tt.lineStart, tok.LineStart)
+		}
+	}
+}
+
+func TestPositions(t *testing.T) {
+	input := "let x = 5;\n  add(x,\n\t`a\nb`) /* c\n*/ y \"\\q\""
+	expected := []struct {
+		literal string
+		line    int
+		column  int
+	}{
+		{"let", 1, 1},
+		{"x", 1, 5},
+		{"=", 1, 7},
+		{"5", 1, 9},
+		{";", 1, 10},
+		{"add", 2, 3},
+		{"(", 2, 6},
+		{"x", 2, 7},
+		{",", 2, 8},
+		{"a\nb", 3, 2},
+		{")", 4, 3},
+		{"y", 5, 4},
+		{"\\q", 5, 6},
+		{"", 5, 10},
+	}
+
+	l := New(input)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Literal != tt.literal {
+			t.Fatalf("tests[%d] - literal wrong. Expected %q, got %q", i, tt.literal, tok.Literal)
+		}
+		if tok.Line != tt.line || tok.Column != tt.column {
+			t.Errorf("tests[%d] - %q position wrong. Expected %d:%d, got %d:%d", i, tok.Literal, tt.line, tt.column, tok.Line, tok.Column)
+		}
+		if pos := tok.Pos(); pos.Offset != tok.Offset || pos.String() != fmt.Sprintf("%d:%d", tt.line, tt.column) {
+			t.Errorf("tests[%d] - Pos wrong. got %+v", i, pos)
+		}
+	}
+
+	errors := l.Errors()
+	if len(errors) != 1 || errors[0].Line != 5 || errors[0].Column != 7 {
+		t.Errorf("wrong errors. got=%+v", errors)
+	}
+}
+
+func TestRescanPositions(t *testing.T) {
+	l := New("x\n  / 2\n  / 3")
+	for _, want := range []string{"x", "/", "2", "/"} {
+		if tok := l.NextToken(); tok.Literal != want {
+			t.Fatalf("literal wrong. Expected %q, got %q", want, tok.Literal)
+		}
+	}
+	// the parser rescans the second / as a regex after reading past it
+	tok := l.ScanRegex(strings.LastIndexByte(l.input, '/'))
+	if tok.Line != 3 || tok.Column != 3 {
+		t.Errorf("regex position wrong. Expected 3:3, got %d:%d", tok.Line, tok.Column)
+	}
+	l = New("f(\n<<EOF\ntext\nEOF\n)")
+	l.NextToken()
+	l.NextToken()
+	shl := l.NextToken()
+	l.NextToken()
+	tok = l.ScanHeredoc(shl.Offset)
+	if tok.Line != 2 || tok.Column != 1 {
+		t.Errorf("heredoc position wrong. Expected 2:1, got %d:%d", tok.Line, tok.Column)
+	}
+	if tok = l.NextToken(); tok.Type != token.RPAREN || tok.Line != 5 || tok.Column != 1 {
+		t.Errorf("token after heredoc wrong. Expected ) at 5:1, got %q at %d:%d", tok.Literal, tok.Line, tok.Column)
+	}
+}
+
+func TestNewFromReader(t *testing.T) {
+	script := "let s = \"a ${b} c\";\nlet h = <<~EOF\n  text\n  EOF\nlet r = x /* c */ >> 2; // end\n"
+	// large enough to take several reads, each boundary somewhere else
+	input := "#!/bin/monkey\n" + strings.Repeat(script, 3*minChunk/len(script))
+
+	want := New(input, WithComments())
+	got := NewFromReader(iotest.HalfReader(strings.NewReader(input)), WithComments())
+	for i := 0; ; i++ {
+		expected, tok := want.NextToken(), got.NextToken()
+		if expected.Type == token.SHL && tok.Type == token.SHL {
+			expected, tok = want.ScanHeredoc(expected.Offset), got.ScanHeredoc(tok.Offset)
+		}
+		if tok != expected {
+			t.Fatalf("token %d wrong. want %+v, got %+v", i, expected, tok)
+		}
+		if tok.Type == token.EOF {
+			break
+		}
+	}
+	if len(got.Errors()) != 0 {
+		t.Errorf("unexpected errors %v", got.Errors())
+	}
+}
+
+func TestNewFromReaderStream(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	go w.Write([]byte("let x = 1;\n")) // the writer stays open afterwards
+
+	tokens := make(chan string)
+	go func() {
+		l := NewFromReader(r)
+		for i := 0; i < 5; i++ {
+			tokens <- l.NextToken().Literal
+		}
+	}()
+	var literals []string
+	for i := 0; i < 5; i++ {
+		select {
+		case lit := <-tokens:
+			literals = append(literals, lit)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("lexer blocked waiting for more input after %q", literals)
+		}
+	}
+	if got := strings.Join(literals, " "); got != "let x = 1 ;" {
+		t.Errorf("wrong tokens. got=%q", got)
+	}
+}
+
+func TestNewFromReaderError(t *testing.T) {
+	r := io.MultiReader(strings.NewReader("let x\n= 1"), iotest.ErrReader(errors.New("connection reset")))
+	l := NewFromReader(r)
+	var literals []string
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		literals = append(literals, tok.Literal)
+	}
+	if got := strings.Join(literals, " "); got != "let x = 1" {
+		t.Errorf("wrong tokens. got=%q", got)
+	}
+	errs := l.Errors()
+	if len(errs) != 1 || errs[0].Msg != "reading input: connection reset" || errs[0].Line != 2 || errs[0].Column != 4 {
+		t.Fatalf("wrong errors. got=%+v", errs)
+	}
+}
+
+// stallReader returns its input, then no bytes and no error forever.
+type stallReader struct{ r io.Reader }
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err == io.EOF {
+		return 0, nil
+	}
+	return n, err
+}
+
+func TestNewFromReaderNoProgress(t *testing.T) {
+	l := NewFromReader(&stallReader{strings.NewReader("let x = 1")})
+	var literals []string
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		literals = append(literals, tok.Literal)
+	}
+	if got := strings.Join(literals, " "); got != "let x = 1" {
+		t.Errorf("wrong tokens. got=%q", got)
+	}
+	errs := l.Errors()
+	if len(errs) != 1 || errs[0].Msg != "reading input: "+io.ErrNoProgress.Error() {
+		t.Fatalf("wrong errors. got=%+v", errs)
+	}
+}
@@ -0,0 +1,90 @@
+package lexer
+
+import (
+	"errors"
+	"interpreter/token"
+	"io"
+	"unsafe"
+)
+
+// minChunk is the size of the first buffer input is read into; it doubles
+// whenever it fills, so the input is copied O(log n) times overall however
+// little each read returns.
+const minChunk = 64 << 10
+
+// maxEmptyReads is how many reads in a row may return no bytes and no error
+// before the reader is given up on with io.ErrNoProgress, as bufio does.
+const maxEmptyReads = 100
+
+// NewFromReader returns a lexer reading its input from r as it goes, so a
+// parse can start before a large script or a network stream has arrived in
+// full. A token is returned as soon as the bytes ending it have been read,
+// without waiting for a buffer to fill, so a REPL or a stream that stays open
+// can be lexed line by line.
+//
+// Token literals are slices of the input, as with New, so everything read is
+// kept in memory for the life of the lexer; the input is not split up, but
+// nothing has to be read into a string before lexing starts. Reading stops at
+// the first error; an error other than io.EOF is reported like a scanning
+// error, and the input read up to it is lexed as if it ended there.
+func NewFromReader(r io.Reader, opts ...Option) *Lexer {
+	l := &Lexer{src: r, names: token.NewInterner(), line: 1}
+	for _, opt := range opts {
+		opt(l)
+	}
+	l.readChar()
+	l.skipShebang()
+	return l
+}
+
+// available reports whether the input is at least n bytes long, reading more
+// of it from a reader if need be.
+func (l *Lexer) available(n int) bool {
+	for len(l.input) < n {
+		if !l.more() {
+			return false
+		}
+	}
+	return true
+}
+
+// more appends what the reader has ready to l.input and reports whether
+// there was any. It returns after the first read that yields bytes, so it only
+// blocks while none have arrived. It is false for a lexer created with New.
+//
+// Input is read into the free end of l.buf and l.input is a string view of
+// the filled part, made with unsafe.String. That is only sound because no
+// byte of l.buf is written once it is inside len(l.buf): reads only fill the
+// free end, and a full buffer is replaced by a new one twice the size, never
+// reused, so every string taken from an earlier view stays valid. Nothing else
+// may write to l.buf.
+func (l *Lexer) more() bool {
+	if l.src == nil {
+		return false
+	}
+	if len(l.buf) == cap(l.buf) {
+		grown := make([]byte, len(l.buf), max(2*cap(l.buf), minChunk))
+		copy(grown, l.buf)
+		l.buf = grown
+	}
+	for empty := 0; ; empty++ {
+		if empty == maxEmptyReads {
+			l.src = nil
+			l.addError(len(l.input), "reading input: %v", io.ErrNoProgress)
+			return false
+		}
+		n, err := l.src.Read(l.buf[len(l.buf):cap(l.buf)])
+		l.buf = l.buf[:len(l.buf)+n]
+		l.input = unsafe.String(unsafe.SliceData(l.buf), len(l.buf))
+		if err != nil {
+			l.src = nil
+			if !errors.Is(err, io.EOF) {
+				l.addError(len(l.input), "reading input: %v", err)
+			}
+			return n > 0
+		}
+		if n > 0 {
+			return true
+		}
+	}
+}
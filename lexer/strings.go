@@ -0,0 +1,295 @@
+package lexer
+
+import (
+	"interpreter/token"
+	"strings"
+)
+
+// readString reads a double-quoted string. The literal is the text between
+// the quotes with escape sequences decoded; the span includes the quotes. An
+// unterminated string is reported and ILLEGAL.
+//
+// A string containing "${" is split around each interpolated expression: the
+// text before it is a STRING_HEAD, and once the matching "}" is reached
+// readString is called again with resume set to scan the following text as a
+// STRING_MID or, at the closing quote, a STRING_TAIL.
+func (l *Lexer) readString(resume bool) token.Token {
+	start := l.position
+	escaped := false
+	for {
+		l.readChar()
+		if l.ch == '\\' {
+			escaped = true
+			l.readChar() // whatever follows cannot end the string
+			if l.ch == 0 {
+				break
+			}
+			continue
+		}
+		if l.ch == '"' || l.ch == 0 || l.ch == '$' && l.peekChar() == '{' {
+			break
+		}
+	}
+	if l.ch == 0 {
+		l.addError(start, "unterminated string")
+		return l.newToken(token.ILLEGAL, start)
+	}
+	end := l.position
+	var t token.TokenType
+	switch {
+	case l.ch == '"' && resume:
+		t = token.STRING_TAIL
+	case l.ch == '"':
+		t = token.STRING
+	case resume:
+		t = token.STRING_MID
+	default:
+		t = token.STRING_HEAD
+	}
+	if l.ch == '$' {
+		l.readChar()
+		l.interps = append(l.interps, 0)
+	}
+	l.readChar()
+	tok := l.newToken(t, start)
+	tok.Literal = l.input[start+1 : end]
+	if escaped {
+		tok.Literal = l.unescape(tok.Literal, start+1)
+	}
+	return tok
+}
+
+// readRawString reads a backtick-quoted string. The literal is the text
+// between the backticks exactly as written: there are no escape sequences and
+// it may span lines. An unterminated raw string is reported and ILLEGAL.
+func (l *Lexer) readRawString() token.Token {
+	start := l.position
+	for {
+		l.readChar()
+		if l.ch == '`' || l.ch == 0 {
+			break
+		}
+	}
+	if l.ch == 0 {
+		l.addError(start, "unterminated raw string")
+		return l.newToken(token.ILLEGAL, start)
+	}
+	l.readChar()
+	tok := l.newToken(token.RAW_STRING, start)
+	tok.Literal = l.input[start+1 : l.position-1]
+	return tok
+}
+
+// ScanRegex rescans the input from offset, which must hold a '/', as a regex
+// literal /pattern/flags and continues lexing after it. NextToken always
+// returns SLASH for '/', since only the parser knows whether an operand or an
+// operator is expected there. Whatever scanning the token after the '/' did,
+// such as reporting an error or opening a string interpolation, is undone.
+//
+// The literal is the whole /pattern/flags text. The pattern ends at the first
+// '/' that is neither escaped nor inside a [...] class, and may not span
+// lines; an unterminated regex is ILLEGAL.
+func (l *Lexer) ScanRegex(offset int) token.Token {
+	l.restore(offset)
+	tok := l.scanRegex(offset)
+	l.locate(&tok)
+	l.last = tok
+	return tok
+}
+
+func (l *Lexer) scanRegex(offset int) token.Token {
+	l.readPosition = offset
+	l.readChar()
+	start := l.position
+	inClass := false
+	for {
+		l.readChar()
+		if l.ch == '\\' {
+			l.readChar()
+		} else if l.ch == '[' {
+			inClass = true
+		} else if l.ch == ']' {
+			inClass = false
+		} else if l.ch == '/' && !inClass {
+			break
+		}
+		if l.ch == '\n' || l.ch == 0 {
+			l.addError(start, "unterminated regular expression")
+			return l.newToken(token.ILLEGAL, start)
+		}
+	}
+	l.readChar()
+	for isLetter(l.ch) {
+		l.readChar()
+	}
+	return l.newToken(token.REGEX, start)
+}
+
+// ScanHeredoc rescans the input from offset, which must hold "<<", as a
+// heredoc and continues lexing after it. Like ScanRegex it is called by the
+// parser, which reads "<<" as a shift unless an operand is expected, and
+// undoes whatever scanning the token after the "<<" did.
+//
+// A heredoc names its own terminator: <<TAG, then the text on the following
+// lines up to a line holding only TAG, ignoring indentation, or TAG followed
+// by ; and ). Lexing resumes right after that TAG, so the statement can end
+// there, as in "TAG;" or "TAG);". With <<~TAG
+// the indentation common to the non-blank lines is stripped, so the text can
+// be indented with the code. The text is kept as written, without escape
+// sequences, and returned as a STRING.
+func (l *Lexer) ScanHeredoc(offset int) token.Token {
+	l.restore(offset)
+	tok := l.scanHeredoc(offset)
+	l.locate(&tok)
+	l.last = tok
+	return tok
+}
+
+func (l *Lexer) scanHeredoc(offset int) token.Token {
+	l.readPosition = offset + 2 // past "<<"
+	l.readChar()
+	start := offset
+	strip := l.ch == '~'
+	if strip {
+		l.readChar()
+	}
+	tag := l.readIdentifier()
+	if tag == "" {
+		l.addError(start, "expected a heredoc terminator after <<")
+		return l.newToken(token.ILLEGAL, start)
+	}
+	for l.ch == ' ' || l.ch == '\t' || l.ch == '\r' {
+		l.readChar()
+	}
+	if l.ch != '\n' {
+		l.addError(l.position, "heredoc text must start on the line after <<%s", tag)
+		return l.newToken(token.ILLEGAL, start)
+	}
+
+	var lines []string
+	for pos := l.position + 1; ; {
+		if !l.available(pos + 1) {
+			l.readPosition = len(l.input)
+			l.readChar()
+			l.addError(start, "unterminated heredoc, expected %s", tag)
+			return l.newToken(token.ILLEGAL, start)
+		}
+		end := strings.IndexByte(l.input[pos:], '\n')
+		for end < 0 && l.more() {
+			end = strings.IndexByte(l.input[pos:], '\n')
+		}
+		if end < 0 {
+			end = len(l.input) - pos
+		}
+		line := l.input[pos : pos+end]
+		if rest := strings.TrimLeft(line, " \t"); isTerminator(rest, tag) {
+			l.readPosition = pos + len(line) - len(rest) + len(tag)
+			l.readChar()
+			break
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\r"))
+		pos += end + 1
+	}
+
+	tok := l.newToken(token.STRING, start)
+	if strip {
+		stripIndent(lines)
+	}
+	tok.Literal = strings.Join(lines, "\n")
+	return tok
+}
+
+// isTerminator reports whether line is tag alone, or tag followed by nothing
+// but the ; and ) that can close the expression. A text line that merely
+// starts with the word, as "EOF is done" does, is not a terminator.
+func isTerminator(line, tag string) bool {
+	rest, ok := strings.CutPrefix(line, tag)
+	return ok && strings.Trim(rest, ";) \t\r") == ""
+}
+
+// stripIndent removes the leading spaces and tabs common to the non-blank
+// lines from every line.
+func stripIndent(lines []string) {
+	indent := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		n := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	if indent <= 0 {
+		return
+	}
+	for i, line := range lines {
+		lines[i] = line[min(indent, len(line)):]
+	}
+}
+
+// unescape decodes \n, \t, \", \\, \$ and \uXXXX in s, which starts at
+// offset in the input. Invalid sequences are reported and kept as written.
+func (l *Lexer) unescape(s string, offset int) string {
+	var out strings.Builder
+	out.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			out.WriteByte(s[i])
+			continue
+		}
+		if i+1 == len(s) {
+			l.addError(offset+i, "invalid escape sequence %q in string", s[i:])
+			out.WriteString(s[i:])
+			break
+		}
+		switch c := s[i+1]; c {
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		case '"':
+			out.WriteByte('"')
+		case '\\':
+			out.WriteByte('\\')
+		case '$':
+			out.WriteByte('$')
+		case 'u':
+			r, ok := decodeHex(s[i+2:], 4)
+			if !ok {
+				l.addError(offset+i, "invalid escape sequence %q in string", s[i:min(i+6, len(s))])
+				out.WriteString(s[i : i+2])
+				i++
+				continue
+			}
+			out.WriteRune(r)
+			i += 4
+		default:
+			l.addError(offset+i, "invalid escape sequence %q in string", s[i:i+2])
+			out.WriteString(s[i : i+2])
+		}
+		i++
+	}
+	return out.String()
+}
+
+// decodeHex decodes the first n characters of s as a hexadecimal rune.
+func decodeHex(s string, n int) (rune, bool) {
+	if len(s) < n {
+		return 0, false
+	}
+	var r rune
+	for _, c := range []byte(s[:n]) {
+		switch {
+		case '0' <= c && c <= '9':
+			r = r<<4 | rune(c-'0')
+		case 'a' <= c && c <= 'f':
+			r = r<<4 | rune(c-'a'+10)
+		case 'A' <= c && c <= 'F':
+			r = r<<4 | rune(c-'A'+10)
+		default:
+			return 0, false
+		}
+	}
+	return r, true
+}
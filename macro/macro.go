@@ -0,0 +1,126 @@
+// Package macro expands macros, rewriting a parsed program before it is
+// evaluated.
+//
+// A macro is bound with let and called like a function:
+//
+//	let unless = macro(cond, body) { quote(if (!(unquote(cond))) { unquote(body) }) };
+//	unless(x > 10, puts("small"));
+//
+// Arguments are not evaluated. The call is replaced by the code the macro
+// quotes, with each unquote(param) replaced by the argument's syntax tree.
+package macro
+
+import (
+	"fmt"
+	"interpreter/ast"
+)
+
+// Macros maps a macro's name to its definition.
+type Macros map[string]*ast.MacroLiteral
+
+// Define collects the top-level let statements that bind a macro and removes
+// them from program, leaving only code to be evaluated.
+func Define(program *ast.Program) Macros {
+	macros := Macros{}
+	kept := program.Statements[:0]
+	for _, stmt := range program.Statements {
+		if let, ok := stmt.(*ast.LetStatement); ok && len(let.Names) <= 1 {
+			if lit, ok := let.Value.(*ast.MacroLiteral); ok {
+				macros[let.Name.Value] = lit
+				continue
+			}
+		}
+		kept = append(kept, stmt)
+	}
+	program.Statements = kept
+	return macros
+}
+
+// Expand returns a copy of program with every call to one of macros replaced
+// by its expansion. Expansions are expanded in turn, so a macro may use
+// another. program itself is not modified.
+func Expand(program *ast.Program, macros Macros) (*ast.Program, error) {
+	e := &expander{macros: macros}
+	expanded := ast.Rewrite(program, e.expandCall).(*ast.Program)
+	return expanded, e.err
+}
+
+// maxExpansions bounds nested expansion so a macro that expands to a call of
+// itself fails instead of recursing forever.
+const maxExpansions = 1000
+
+type expander struct {
+	macros Macros
+	depth  int
+	err    error // the first error found
+}
+
+func (e *expander) expandCall(node ast.Node) ast.Node {
+	call, ok := node.(*ast.CallExpression)
+	if !ok || e.err != nil {
+		return node
+	}
+	ident, ok := call.Function.(*ast.Identifier)
+	if !ok {
+		return node
+	}
+	lit, ok := e.macros[ident.Value]
+	if !ok {
+		return node
+	}
+	if len(call.Arguments) != len(lit.Parameters) {
+		e.err = fmt.Errorf("macro %s takes %d arguments, got %d", ident.Value, len(lit.Parameters), len(call.Arguments))
+		return node
+	}
+	quote := quoted(lit.Body)
+	if quote == nil {
+		e.err = fmt.Errorf("macro %s: body must be a single quote(...)", ident.Value)
+		return node
+	}
+
+	args := make(map[string]ast.Expression, len(lit.Parameters))
+	for i, param := range lit.Parameters {
+		args[param.Value] = call.Arguments[i]
+	}
+	expansion := ast.Rewrite(quote.Node, func(node ast.Node) ast.Node {
+		unquote, ok := node.(*ast.UnquoteExpression)
+		if !ok {
+			return node
+		}
+		if param, ok := unquote.Node.(*ast.Identifier); ok {
+			if arg, ok := args[param.Value]; ok {
+				return arg
+			}
+		}
+		if e.err == nil {
+			e.err = fmt.Errorf("macro %s: only parameters can be unquoted, got unquote(%s)", ident.Value, unquote.Node)
+		}
+		return node
+	})
+
+	e.depth++
+	defer func() { e.depth-- }()
+	if e.depth > maxExpansions {
+		e.err = fmt.Errorf("macro %s: expansion nested more than %d deep", ident.Value, maxExpansions)
+		return node
+	}
+	return ast.Rewrite(expansion, e.expandCall)
+}
+
+// quoted returns the quote(...) that makes up a macro body, or nil if the
+// body is anything else. Bodies are not evaluated, so there is nowhere for
+// other code to run.
+func quoted(body *ast.BlockStatement) *ast.QuoteExpression {
+	if body == nil || len(body.Statements) != 1 {
+		return nil
+	}
+	var value ast.Expression
+	switch stmt := body.Statements[0].(type) {
+	case *ast.ExpressionStatement:
+		value = stmt.Expression
+	case *ast.ReturnStatement:
+		value = stmt.ReturnValue
+	}
+	quote, _ := value.(*ast.QuoteExpression)
+	return quote
+}
@@ -0,0 +1,146 @@
+package macro
+
+import (
+	"interpreter/ast"
+	"interpreter/lexer"
+	"interpreter/parser"
+	"testing"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %q", input, p.Errors())
+	}
+	return program
+}
+
+func TestDefine(t *testing.T) {
+	input := `
+	let number = 1;
+	let function = fn(x, y) { x + y };
+	let mymacro = macro(x, y) { quote(x + y) };
+	`
+	program := parse(t, input)
+	macros := Define(program)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("wrong number of statements. got=%d", len(program.Statements))
+	}
+	if _, ok := macros["number"]; ok {
+		t.Errorf("number should not be defined")
+	}
+	if _, ok := macros["function"]; ok {
+		t.Errorf("function should not be defined")
+	}
+	macro, ok := macros["mymacro"]
+	if !ok {
+		t.Fatalf("macro not defined")
+	}
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("wrong number of macro parameters. got=%d", len(macro.Parameters))
+	}
+	if got := macro.Body.String(); got != "quote((x + y))" {
+		t.Errorf("body is not %q. got=%q", "quote((x + y))", got)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let infix = macro() { quote(1 + 2) };
+			infix();`,
+			`(1 + 2)`,
+		},
+		{
+			`let reverse = macro(a, b) { quote(unquote(b) - unquote(a)) };
+			reverse(2 + 2, 10 - 5);`,
+			`(10 - 5) - (2 + 2)`,
+		},
+		{
+			`let unless = macro(cond, cons, alt) {
+				quote(if (!(unquote(cond))) { unquote(cons); } else { unquote(alt); });
+			};
+			unless(10 > 5, puts("not greater"), puts("greater"));`,
+			`if (!(10 > 5)) { puts("not greater") } else { puts("greater") }`,
+		},
+		{
+			`let twice = macro(x) { quote(unquote(x) + unquote(x)) };
+			let inc = macro(x) { quote(twice(unquote(x)) + 1) };
+			inc(y);`,
+			`(y + y) + 1`,
+		},
+		{
+			`let twice = macro(x) { quote(unquote(x) + unquote(x)) };
+			twice(1); twice(2);`,
+			`(1 + 1); (2 + 2)`,
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		expected := parse(t, tt.expected)
+
+		expanded, err := Expand(program, Define(program))
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.input, err)
+			continue
+		}
+		if expanded.String() != expected.String() {
+			t.Errorf("not equal. want=%q, got=%q", expected.String(), expanded.String())
+		}
+	}
+}
+
+func TestExpandLeavesInputUntouched(t *testing.T) {
+	program := parse(t, "let id = macro(x) { quote(unquote(x)) }; id(1);")
+	macros := Define(program)
+	before := program.String()
+
+	if _, err := Expand(program, macros); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if program.String() != before {
+		t.Errorf("program modified. want=%q, got=%q", before, program.String())
+	}
+	if got := macros["id"].Body.String(); got != "quote(unquote(x))" {
+		t.Errorf("macro body modified. got=%q", got)
+	}
+}
+
+func TestExpandErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{
+			"let m = macro(a) { quote(a) }; m(1, 2);",
+			"macro m takes 1 arguments, got 2",
+		},
+		{
+			"let m = macro(a) { a }; m(1);",
+			"macro m: body must be a single quote(...)",
+		},
+		{
+			"let m = macro(a) { quote(unquote(a + 1)) }; m(1);",
+			"macro m: only parameters can be unquoted, got unquote((a + 1))",
+		},
+		{
+			"let m = macro() { quote(m()) }; m();",
+			"macro m: expansion nested more than 1000 deep",
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		_, err := Expand(program, Define(program))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: wrong error. want=%q, got=%v", tt.input, tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"interpreter/token"
+	"strconv"
+	"strings"
+)
+
+// Code identifies the kind of a ParseError, so tools can match on it instead
+// of on the message. Codes are stable: new ones are only ever added at the
+// end. String renders them as E001, E002 and so on.
+type Code int
+
+const (
+	CodeUnexpectedToken      Code = iota + 1 // E001, Expected is set
+	CodeNoPrefixParseFn                      // E002
+	CodeBadInteger                           // E003
+	CodeFeatureDisabled                      // E004, args: Feature, first Version with it, parser Version
+	CodeMaxDepth                             // E005, args: depth limit
+	CodeInvalidAssignTarget                  // E006
+	CodeLexer                                // E007, args: lexer message
+	CodeYieldOutsideFunction                 // E008
+	CodeNotOverloadable                      // E009
+	CodeChainedComparison                    // E010, args: the comparison before the token
+	CodeIncomplete                           // E011, args: the innermost unclosed bracket
+	CodeUnusedExpression                     // E012, args: the expression
+	CodeVariadicMacro                        // E013
+)
+
+func (c Code) String() string {
+	return fmt.Sprintf("E%03d", int(c))
+}
+
+// ErrIncomplete is wrapped by the error reported when the input ends inside
+// brackets, see WithIncompleteDetection.
+var ErrIncomplete = errors.New("incomplete input")
+
+// ParseError is a single syntax error. It keeps the data describing the
+// error and only builds the message when Error is called, so parses whose
+// errors are never looked at don't pay for formatting.
+type ParseError struct {
+	Token    token.Token     // token the error was reported at
+	Pos      token.Position  // where the error is; the start of Token unless the lexer reported it
+	Expected token.TokenType // expected token type, for unexpected tokens
+	Code     Code
+	args     []any
+}
+
+// Error returns the message prefixed with the line and column, as in
+// "3:7: Expected token ) -- Got ;".
+func (e *ParseError) Error() string {
+	return e.Pos.String() + ": " + e.message()
+}
+
+// Unwrap returns ErrIncomplete for an error reported because the input ended
+// too early, and nil for any other.
+func (e *ParseError) Unwrap() error {
+	if e.Code == CodeIncomplete {
+		return ErrIncomplete
+	}
+	return nil
+}
+
+func (e *ParseError) message() string {
+	switch e.Code {
+	case CodeUnexpectedToken:
+		return fmt.Sprintf("Expected token %s -- Got %s", e.Expected, e.Token.Type)
+	case CodeNoPrefixParseFn:
+		return fmt.Sprintf("no prefix parse function for %s found", e.Token.Type)
+	case CodeBadInteger:
+		return fmt.Sprintf("failed to parse %q to integer", e.Token.Literal)
+	case CodeFeatureDisabled:
+		return fmt.Sprintf("feature %s not enabled at %q -- requires version %d, have %d", e.args[0], e.Token.Literal, e.args[1], e.args[2])
+	case CodeInvalidAssignTarget:
+		return fmt.Sprintf("cannot assign with %s to a non-identifier", e.Token.Literal)
+	case CodeLexer:
+		return e.args[0].(string)
+	case CodeYieldOutsideFunction:
+		return "yield outside of a function body"
+	case CodeNotOverloadable:
+		return fmt.Sprintf("cannot define operator %s", e.Token.Type)
+	case CodeUnusedExpression:
+		return fmt.Sprintf("%s is evaluated but not used", e.args[0])
+	case CodeVariadicMacro:
+		return "variadic macro parameters are not supported"
+	case CodeIncomplete:
+		return fmt.Sprintf("input ends before %s is closed", e.args[0])
+	case CodeChainedComparison:
+		return fmt.Sprintf("comparisons cannot be chained at %q -- %s would be compared with what follows, join comparisons with &&", e.Token.Literal, e.args[0])
+	case CodeMaxDepth:
+		return fmt.Sprintf("maximum nesting depth of %d exceeded at %q", e.args[0], e.Token.Literal)
+	}
+	return fmt.Sprintf("syntax error at %q", e.Token.Literal)
+}
+
+// bailout is panicked with to abandon the rest of a parse. ParseProgram
+// recovers it; the reason has already been recorded as an error.
+type bailout struct{}
+
+func (p *Parser) recoverBailout() {
+	if r := recover(); r != nil {
+		if _, ok := r.(bailout); !ok {
+			panic(r)
+		}
+		p.depth = 0
+		p.yielded = nil
+	}
+}
+
+// defaultErrorLimit is the number of errors after which a parse stops, see
+// WithErrorLimit.
+const defaultErrorLimit = 20
+
+// addError records an error with code at tok. Once the error limit is reached
+// further errors are dropped, and ParseProgram stops after the statement it
+// is in.
+func (p *Parser) addError(code Code, tok token.Token, args ...any) *ParseError {
+	err := &ParseError{Token: tok, Pos: tok.Pos(), Code: code, args: args}
+	if p.errorLimitReached() {
+		return err
+	}
+	if p.detectIncomplete && tok.Type == token.EOF && len(p.brackets) > 0 {
+		// the error is only for lack of input; report that and stop
+		err.Code, err.args = CodeIncomplete, []any{p.brackets[len(p.brackets)-1]}
+		p.incomplete = true
+	}
+	p.errors = append(p.errors, err)
+	return err
+}
+
+func (p *Parser) errorLimitReached() bool {
+	return p.incomplete || p.errorLimit > 0 && len(p.errors) >= p.errorLimit
+}
+
+// Errors returns the messages of all errors found so far.
+func (p *Parser) Errors() []string {
+	msgs := make([]string, len(p.errors))
+	for i, err := range p.errors {
+		msgs[i] = err.Error()
+	}
+	return msgs
+}
+
+// Err returns the errors found so far as one error, or nil if there are none.
+// It joins them with errors.Join, so errors.As finds each *ParseError and
+// errors.Is finds ErrIncomplete:
+//
+//	program := p.ParseProgram()
+//	if err := p.Err(); err != nil {
+//		return err
+//	}
+func (p *Parser) Err() error {
+	if len(p.errors) == 0 {
+		return nil
+	}
+	errs := make([]error, len(p.errors))
+	for i, err := range p.errors {
+		errs[i] = err
+	}
+	return errors.Join(errs...)
+}
+
+// ParseErrors returns the errors found so far without formatting them.
+func (p *Parser) ParseErrors() []*ParseError {
+	return p.errors
+}
+
+// FormatErrors renders errs for display, each with the line of src it was
+// found on and a caret under the offending token:
+//
+//	error[E001]: Expected token IDENT -- Got =
+//	 --> 1:5
+//	  |
+//	1 | let = 5;
+//	  |     ^
+//
+// src must be the input the errors were reported for.
+func FormatErrors(src string, errs []*ParseError) string {
+	var out strings.Builder
+	for i, e := range errs {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+		formatError(&out, src, e)
+	}
+	return out.String()
+}
+
+func formatError(out *strings.Builder, src string, e *ParseError) {
+	start := min(max(e.Pos.Offset, 0), len(src))
+	lineStart := strings.LastIndexByte(src[:start], '\n') + 1
+	lineEnd := len(src)
+	if i := strings.IndexByte(src[start:], '\n'); i >= 0 {
+		lineEnd = start + i
+	}
+	line := strings.TrimRight(src[lineStart:lineEnd], "\r")
+
+	width := 1
+	if e.Pos.Offset == e.Token.Offset {
+		width = max(min(e.Token.End, lineEnd)-start, 1)
+	}
+	// keep tabs so the caret lines up however they are displayed
+	pad := []byte(src[lineStart:start])
+	for i, c := range pad {
+		if c != '\t' {
+			pad[i] = ' '
+		}
+	}
+
+	number := strconv.Itoa(e.Pos.Line)
+	gutter := strings.Repeat(" ", len(number))
+	fmt.Fprintf(out, "error[%s]: %s\n", e.Code, e.message())
+	fmt.Fprintf(out, "%s--> %s\n", gutter, e.Pos)
+	fmt.Fprintf(out, "%s |\n", gutter)
+	fmt.Fprintf(out, "%s | %s\n", number, line)
+	fmt.Fprintf(out, "%s | %s%s\n", gutter, pad, strings.Repeat("^", width))
+}
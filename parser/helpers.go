@@ -1,8 +1,9 @@
 package parser
 
 import (
-	"fmt"
+	"interpreter/ast"
 	"interpreter/token"
+	"strings"
 )
 
 func (p *Parser) curTokenIs(t token.TokenType) bool {
@@ -14,14 +15,91 @@ func (p *Parser) peekTokenIs(t token.TokenType) bool {
 	return p.peekToken.Type == t
 }
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("Expected token %s -- Got %s", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.addError(CodeUnexpectedToken, p.peekToken).Expected = t
 }
 
 // nextToken Advances the scanner to next token. Similar to peekchar, but with tokens
+//   - COMMENT tokens from a lexer created WithComments are skipped, a newline
+//     before or in one counting as a newline before the token that follows.
+//     WithCommentPreservation they are collected in p.comments first.
+//   - keywords newer than the parser's Version are identifiers, see
+//     keywordVersions.
+//   - errors the lexer found while scanning are copied into p.errors.
+//   - brackets opened and closed by the new current token are tracked in
+//     p.brackets.
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
+	p.curComments, p.peekComments = p.peekComments, len(p.comments)
+	switch p.curToken.Type {
+	case token.LPAREN, token.LBRACKET, token.LBRACE:
+		p.brackets = append(p.brackets, p.curToken.Type)
+	case token.RPAREN, token.RBRACKET, token.RBRACE:
+		if n := len(p.brackets); n > 0 {
+			p.brackets = p.brackets[:n-1]
+		}
+	}
 	p.peekToken = p.l.NextToken()
+	for p.peekToken.Type == token.COMMENT {
+		if p.keepComments {
+			p.comments = append(p.comments, p.peekToken)
+		}
+		lineStart := p.peekToken.LineStart || strings.Contains(p.peekToken.Literal, "\n")
+		p.peekToken = p.l.NextToken()
+		p.peekToken.LineStart = p.peekToken.LineStart || lineStart
+	}
+	if p.version < keywordVersions[p.peekToken.Type] {
+		p.peekToken.Type = token.IDENT
+	}
+	if lexErrors := p.l.Errors(); len(lexErrors) > p.lexErrors {
+		for _, err := range lexErrors[p.lexErrors:] {
+			p.addError(CodeLexer, p.peekToken, err.Msg).Pos = token.Position{Offset: err.Offset, Line: err.Line, Column: err.Column}
+		}
+		p.lexErrors = len(lexErrors)
+	}
+}
+
+// rescan replaces peekToken, which the lexer scanned taking the current token
+// for an operator, with the token scan returns for the input from the current
+// token on, and moves to it. The lexer errors reported for the dropped token
+// are dropped with it.
+func (p *Parser) rescan(scan func(offset int) token.Token) {
+	p.peekToken = scan(p.curToken.Offset)
+	for n := len(p.l.Errors()); p.lexErrors > n; p.lexErrors-- {
+		if last := len(p.errors) - 1; last >= 0 && p.errors[last].Code == CodeLexer {
+			p.errors = p.errors[:last]
+		}
+	}
+	p.nextToken()
+}
+
+// leadingComments returns the comments between the current token and the one
+// before it that are not trailing comments of an earlier statement.
+func (p *Parser) leadingComments() []token.Token {
+	return p.comments[max(p.curComments, p.attached):p.peekComments]
+}
+
+// attachComments sets the trivia of stmt, which ends at the current token, to
+// leading and to the comments following it on the same line.
+func (p *Parser) attachComments(stmt ast.Statement, leading []token.Token) {
+	c, ok := stmt.(ast.Commented)
+	if !ok {
+		return
+	}
+	trivia := c.Comments()
+	trivia.Leading = leading
+	end := p.peekComments
+	for end < len(p.comments) && p.comments[end].Line == p.curToken.Line {
+		end++
+	}
+	trivia.Trailing = p.comments[p.peekComments:end]
+	p.attached = end
+}
+
+// inBrackets reports whether the current token is inside ( ) or [ ], with no
+// { } nearer to it. Newlines don't end statements there.
+func (p *Parser) inBrackets() bool {
+	n := len(p.brackets)
+	return n > 0 && p.brackets[n-1] != token.LBRACE
 }
 
 // expectPeek assertion functions. Enforces correctness of the token order by checking type of next token.
@@ -37,8 +115,8 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 
 // peekPrecedence peeks the next token. If empty returns 0.
 func (p *Parser) peekPrecedence() int {
-	if p, ok := precedences[p.peekToken.Type]; ok {
-		return p
+	if prec := p.precedences[p.peekToken.Type]; prec != 0 {
+		return prec
 	}
 	return LOWEST
 }
@@ -46,8 +124,25 @@ func (p *Parser) peekPrecedence() int {
 // curPrecedence returns current precedence from table
 // - It tells that +( token.Plus) and - have the same precedence
 func (p *Parser) curPrecedence() int {
-	if p, ok := precedences[p.curToken.Type]; ok {
-		return p
+	if prec := p.precedences[p.curToken.Type]; prec != 0 {
+		return prec
 	}
 	return LOWEST
 }
+
+// defaultMaxDepth bounds the nesting of expressions and blocks, so hostile
+// input such as thousands of "(" or "while (x) {" fails with an error instead
+// of exhausting the Go stack.
+const defaultMaxDepth = 4096
+
+// enter increases the nesting depth, bailing out of the parse once it passes
+// maxDepth. Every enter is paired with a deferred leave.
+func (p *Parser) enter() {
+	p.depth++
+	if p.depth > p.maxDepth {
+		p.addError(CodeMaxDepth, p.curToken, p.maxDepth)
+		panic(bailout{})
+	}
+}
+
+func (p *Parser) leave() { p.depth-- }
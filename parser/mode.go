@@ -0,0 +1,49 @@
+package parser
+
+import "interpreter/ast"
+
+// Mode selects how forgiving the parser is, see WithMode.
+type Mode int
+
+const (
+	ModeDefault    Mode = iota // newlines end statements
+	ModeStrict                 // for scripts checked before they run
+	ModePermissive             // for a REPL, reading input line by line
+)
+
+// WithMode sets how forgiving the parse is. Compared to ModeDefault:
+//
+//   - ModeStrict requires a semicolon after every statement that does not end
+//     in a block, as WithStrictSemicolons does, and rejects top-level
+//     expression statements whose value is unused, such as "x + 1;". Calls,
+//     pipes, assignments and if expressions are still allowed.
+//   - ModePermissive reports input ending inside brackets as incomplete, as
+//     WithIncompleteDetection does, so a REPL can read another line.
+//
+// A mode only turns on what it implies and never turns off what another
+// option turned on, so options combine the same way in any order.
+func WithMode(m Mode) Option {
+	return func(p *Parser) {
+		p.mode = m
+		if m == ModeStrict {
+			p.strictSemicolons = true
+		}
+		if m == ModePermissive {
+			p.detectIncomplete = true
+		}
+	}
+}
+
+// checkUsed reports a top-level expression statement whose value would be
+// computed and thrown away, which ModeStrict does not allow.
+func (p *Parser) checkUsed(stmt ast.Statement) {
+	es, ok := stmt.(*ast.ExpressionStatement)
+	if !ok {
+		return
+	}
+	switch es.Expression.(type) {
+	case *ast.CallExpression, *ast.PipeExpression, *ast.CompoundAssign, *ast.IfExpression:
+		return
+	}
+	p.addError(CodeUnusedExpression, es.Token, es.Expression)
+}
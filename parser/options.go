@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"fmt"
+	"interpreter/lexer"
+	"io"
+	"strings"
+)
+
+// Option configures a Parser. Options are applied in order by New.
+type Option func(*Parser)
+
+// WithVersion pins the grammar to v, so names that later became keywords
+// still parse as names in scripts written for an older language version.
+// Newer features are reported as errors; see Version for what is held back.
+func WithVersion(v Version) Option {
+	return func(p *Parser) {
+		p.version = v
+	}
+}
+
+// WithTracer sends an event to t on entry to and exit from the parse
+// functions. Tracing is off by default, which still costs every parse
+// function a deferred call and two calls that return at a nil check.
+func WithTracer(t Tracer) Option {
+	return func(p *Parser) {
+		p.tracer = t
+	}
+}
+
+// WithTracing writes a line to w on entry to and exit from the parse
+// functions, indented by nesting, as in
+//
+//	BEGIN parseExpressionStatement (- "-")
+//		BEGIN parseExpression (- "-")
+//
+// It is WithTracer with a Tracer that formats each TraceEvent.
+func WithTracing(w io.Writer) Option {
+	return WithTracer(func(e TraceEvent) {
+		fmt.Fprintf(w, "%s%s\n", strings.Repeat("\t", e.Depth-1), e)
+	})
+}
+
+// WithMaxDepth makes the parse fail once expressions and blocks nest more
+// than n deep; each parenthesis, operand and { } counts one level. The
+// default of 4096 is far past anything written by hand but well within the
+// Go stack; n <= 0 keeps it.
+func WithMaxDepth(n int) Option {
+	return func(p *Parser) {
+		if n > 0 {
+			p.maxDepth = n
+		}
+	}
+}
+
+// WithStrictSemicolons requires a semicolon after every statement that does
+// not end with a block, instead of letting a newline, } or the end of input
+// end it.
+func WithStrictSemicolons() Option {
+	return func(p *Parser) {
+		p.strictSemicolons = true
+	}
+}
+
+// WithCommentPreservation keeps the comments of the parsed source in
+// Program.Comments and attaches them to the statements they belong to, as
+// ast.Trivia. It switches the lexer to returning them, as
+// lexer.WithComments does, so it has to be passed to New.
+func WithCommentPreservation() Option {
+	return func(p *Parser) {
+		p.keepComments = true
+		lexer.WithComments()(p.l)
+	}
+}
+
+// WithIncompleteDetection tells input that is merely unfinished apart from
+// input that is wrong. When the input ends inside ( ), [ ] or { }, the parse
+// stops with an error wrapping ErrIncomplete instead of the errors the
+// missing text would cause, so a REPL can read another line and try again:
+//
+//	errs := p.ParseErrors()
+//	if len(errs) > 0 && errors.Is(errs[len(errs)-1], parser.ErrIncomplete) {
+//		// prompt for a continuation line
+//	}
+func WithIncompleteDetection() Option {
+	return func(p *Parser) {
+		p.detectIncomplete = true
+	}
+}
+
+// WithDiagnostics writes the errors and warnings of each parse to w once it
+// finishes, one per line in input order, as in
+//
+//	2:5: warning: empty if body
+//	3:7: error[E001]: Expected token ) -- Got ;
+//
+// By default the parser writes nothing; they are only returned by Errors and
+// Warnings.
+func WithDiagnostics(w io.Writer) Option {
+	return func(p *Parser) {
+		p.diagnostics = w
+	}
+}
+
+// WithErrorLimit stops the parse once n errors have been found, so broken
+// input can't bury the first errors under thousands of follow-on ones. The
+// default is 20; n <= 0 removes the limit.
+func WithErrorLimit(n int) Option {
+	return func(p *Parser) {
+		p.errorLimit = max(n, 0)
+	}
+}
+
+// WithFailFast stops the parse at the first error. It is WithErrorLimit(1).
+func WithFailFast() Option {
+	return WithErrorLimit(1)
+}
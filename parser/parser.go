@@ -1,120 +1,328 @@
 package parser
 
 import (
-	"fmt"
 	"interpreter/ast"
 	"interpreter/lexer"
 	"interpreter/token"
+	"io"
 	"strconv"
+	"strings"
 )
 
 // Step 3 Pratt Parser
 type (
-	prefixParseFn func() ast.Expression
-	infixParseFn  func(ast.Expression) ast.Expression
+	prefixParseFn    func() ast.Expression
+	infixParseFn     func(ast.Expression) ast.Expression
+	statementParseFn func() ast.Statement
 )
 
 const (
 	_           int = iota
 	LOWEST          //
+	ASSIGN          // += -= *= /=
+	TERNARY         // a ? b : c
+	PIPE            // |>
+	LOGICAL_OR      // ||
+	LOGICAL_AND     // &&
 	EQUALS          // ==
 	LESSGREATER     // < or >
+	BITWISE_OR      // |
+	BITWISE_XOR     // ^
+	BITWISE_AND     // &
+	SHIFT           // << or >>
+	RANGE           // ..
 	SUM             // +
 	PRODUCT         // *
+	EXPONENT        // **
 	PREFIX          // -x or !x
 	CALL            // myFunc(x)
+	MEMBER          // a.b or a[i]
 )
 
-var precedences = map[token.TokenType]int{
+// precedences is indexed by token type. Types without an entry bind as LOWEST.
+var precedences = [256]int{
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+
+	token.QUESTION: TERNARY,
+	token.PIPE:     PIPE,
+
+	token.OR:  LOGICAL_OR,
+	token.AND: LOGICAL_AND,
+
 	token.EQ:       EQUALS,
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
 	token.GT:       LESSGREATER,
+	token.BIT_OR:   BITWISE_OR,
+	token.BIT_XOR:  BITWISE_XOR,
+	token.BIT_AND:  BITWISE_AND,
+	token.SHL:      SHIFT,
+	token.SHR:      SHIFT,
+	token.DOTDOT:   RANGE,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.SLASH:    PRODUCT,
 	token.ASTERISK: PRODUCT,
+	token.POWER:    EXPONENT,
 	token.LPAREN:   CALL,
+	token.DOT:      MEMBER,
+	token.LBRACKET: MEMBER,
+}
+
+// rightAssoc marks the infix operators that group right to left, so that
+// 2 ** 3 ** 2 is 2 ** (3 ** 2). All others group left to right.
+var rightAssoc = [256]bool{
+	token.POWER: true,
+}
+
+// startsStatement marks the tokens that could either continue an expression
+// or begin the next one. At the start of a line they end the statement before
+// them, so semicolons can be left out:
+//
+//	let x = y
+//	(f || g)(x)
+//
+// is two statements rather than the call y(f || g). An expression split
+// across lines with these operators needs them at the end of the line, unless
+// it is inside ( ) or [ ], where newlines never end a statement.
+var startsStatement = [256]bool{
+	token.LPAREN:   true,
+	token.LBRACKET: true,
+	token.MINUS:    true,
+	token.PLUS:     true,
 }
 
 // Parser has 3 fields
 //   - l *lexer.Lexer
 //   - curToken token.Token
 //   - peekToken token.Token
+//
+// A Parser keeps all of its state on the instance, so separate Parsers can
+// run on different goroutines. A single Parser is not safe for concurrent use.
 type Parser struct {
-	l              *lexer.Lexer
-	curToken       token.Token // current token
-	peekToken      token.Token // next token
-	errors         []string
-	prefixParseFns map[token.TokenType]prefixParseFn
-	inflixParseFns map[token.TokenType]infixParseFn
+	l         *lexer.Lexer
+	curToken  token.Token // current token
+	peekToken token.Token // next token
+	errors    []*ParseError
+	lexErrors int // lexer errors already copied into errors
+	// parse function tables indexed by token type; nil means no entry
+	prefixParseFns    [256]prefixParseFn
+	inflixParseFns    [256]infixParseFn
+	statementParseFns [256]statementParseFn
+	version           Version // grammar revision, see WithVersion
+	tracer            Tracer  // receives trace events, nil when disabled
+	traceLevel        int     // nesting of trace/untrace calls
+	depth             int     // current parseExpression nesting
+	maxDepth          int     // nesting at which parsing bails out
+	yielded           *bool   // set by yield in the innermost function body, nil outside one
+	// the (, [ and { still open at curToken, innermost last
+	brackets []token.TokenType
+	// copies of the package tables, so RegisterInfix only affects this parser
+	precedences [256]int
+	rightAssoc  [256]bool
+	// errors kept before the parse stops, 0 for no limit
+	errorLimit int
+	warnings   []Diagnostic
+	// set by WithStrictSemicolons and WithCommentPreservation
+	strictSemicolons bool
+	keepComments     bool
+	comments         []token.Token
+	// comments from these indexes on precede curToken and peekToken; those
+	// before attached are taken as trailing comments by a statement
+	curComments, peekComments, attached int
+	// set by WithIncompleteDetection, and once input ended inside brackets
+	detectIncomplete bool
+	incomplete       bool
+	mode             Mode      // see WithMode
+	diagnostics      io.Writer // see WithDiagnostics, nil for silence
 }
 
-// registerPrefix adds a Prefix entry to the map
+// registerPrefix adds a Prefix entry to the table
 func (p *Parser) registerPrefix(tokenType token.TokenType, fn prefixParseFn) {
 	p.prefixParseFns[tokenType] = fn
 }
 
-// registerInflix adds nan Inflix entry to the map
+// registerInflix adds an Inflix entry to the table
 func (p *Parser) registerInflix(tokenType token.TokenType, fn infixParseFn) {
 	p.inflixParseFns[tokenType] = fn
 }
 
+// registerStatement adds a statement keyword entry to the table. Tokens
+// without one start an expression statement.
+func (p *Parser) registerStatement(tokenType token.TokenType, fn statementParseFn) {
+	p.statementParseFns[tokenType] = fn
+}
+
+// RegisterPrefix makes tokens of type t a prefix operator of this parser,
+// parsed like -x into a PrefixExpression. The lexer has to scan t, see
+// lexer.WithOperator.
+func (p *Parser) RegisterPrefix(t token.TokenType) {
+	p.registerPrefix(t, p.parsePrefixExpression)
+}
+
+// RegisterInfix makes tokens of type t a left-associative infix operator of
+// this parser, parsed like a + b into an InfixExpression. precedence is one
+// of the levels from LOWEST to EXPONENT, so registering <> at EQUALS makes it
+// bind like ==. The lexer has to scan t, see lexer.WithOperator. Other
+// parsers are not affected.
+func (p *Parser) RegisterInfix(t token.TokenType, precedence int) {
+	p.registerInflix(t, p.parseInfixExpression)
+	p.precedences[t] = precedence
+	p.rightAssoc[t] = false
+}
+
 func (p *Parser) parseIdentifier() ast.Expression {
-	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	defer p.untrace(p.trace("parseIdentifier"))
+	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if p.peekTokenIs(token.LBRACE) && (!p.peekToken.LineStart || p.inBrackets()) {
+		return p.parseStructLiteral(ident)
+	}
+	return ident
 }
 
 // STEP 2 WE ADD ERROR HANDLING
 
 // ParseProgram constructs a root node and builds an AST.
 //   - func(p *Parser) ParseProgram() *ast.Program
-func (p *Parser) ParseProgram() *ast.Program {
+//
+// If parsing has to stop early, e.g. because input is nested too deeply, the
+// statements parsed so far are returned and the reason is in Errors().
+func (p *Parser) ParseProgram() (program *ast.Program) {
 
-	program := &ast.Program{}
+	program = &ast.Program{}
 	program.Statements = []ast.Statement{}
+	defer p.writeDiagnostics()
+	defer p.recoverBailout()
 
-	for p.curToken.Type != token.EOF {
+	for p.curToken.Type != token.EOF && !p.errorLimitReached() {
 		stmt := p.parseStatement()
 		if stmt != nil {
+			if p.mode == ModeStrict {
+				p.checkUsed(stmt)
+			}
 			program.Statements = append(program.Statements, stmt)
 		}
 		p.nextToken()
 	}
+	program.Comments = p.comments
 	return program
 }
-func New(l *lexer.Lexer) *Parser {
+
+// ParseExpressionString parses src as a single expression, such as a config
+// value or a line of calculator input, without wrapping it in a Program. A
+// trailing semicolon is allowed; anything else after the expression is an
+// error. If src does not parse, the error is that of Parser.Err.
+func ParseExpressionString(src string, opts ...Option) (ast.Expression, error) {
+	p := New(lexer.New(src), opts...)
+	exp := p.parseSingleExpression()
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	return exp, nil
+}
+
+func (p *Parser) parseSingleExpression() (exp ast.Expression) {
+	defer p.writeDiagnostics()
+	defer p.recoverBailout()
+	exp = p.parseExpression(LOWEST)
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	if exp != nil {
+		p.expectPeek(token.EOF)
+	}
+	return exp
+}
+
+// New returns a Parser reading tokens from l, configured by opts.
+func New(l *lexer.Lexer, opts ...Option) *Parser {
 	p := &Parser{
-		l:              l,
-		errors:         []string{},
-		inflixParseFns: make(map[token.TokenType]infixParseFn),
-		prefixParseFns: make(map[token.TokenType]prefixParseFn)}
+		l:          l,
+		errors:     []*ParseError{},
+		version:    LatestVersion,
+		maxDepth:   defaultMaxDepth,
+		errorLimit: defaultErrorLimit,
+
+		precedences: precedences,
+		rightAssoc:  rightAssoc,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
 
+	p.registerStatement(token.LET, p.parseLetStatement)
+	p.registerStatement(token.RETURN, p.parseReturnStatement)
+	p.registerStatement(token.WHILE, p.parseWhileStatement)
+	p.registerStatement(token.DO, p.parseDoWhileStatement)
+	p.registerStatement(token.FOR, p.parseForStatement)
+	p.registerStatement(token.IMPORT, p.parseImportStatement)
+	p.registerStatement(token.STRUCT, p.parseStructStatement)
+	p.registerStatement(token.FUNCTION, p.parseFunctionStatement)
+	p.registerStatement(token.YIELD, p.parseYieldStatement)
+	p.registerStatement(token.CLASS, p.parseClassStatement)
+	p.registerStatement(token.ASSERT, p.parseAssertStatement)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefix(token.PLUS, p.parsePrefixExpression)
+	p.registerPrefix(token.BIT_NOT, p.parsePrefixExpression)
 	p.registerInflix(token.EQ, p.parseInfixExpression) // Infix Exprsessions
+	p.registerInflix(token.AND, p.parseInfixExpression)
+	p.registerInflix(token.OR, p.parseInfixExpression)
 	p.registerInflix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInflix(token.LT, p.parseInfixExpression)
 	p.registerInflix(token.GT, p.parseInfixExpression)
 	p.registerInflix(token.PLUS, p.parseInfixExpression)
 	p.registerInflix(token.MINUS, p.parseInfixExpression)
+	p.registerInflix(token.POWER, p.parseInfixExpression)
 	p.registerInflix(token.SLASH, p.parseInfixExpression)
 	p.registerInflix(token.ASTERISK, p.parseInfixExpression)
+	p.registerInflix(token.BIT_AND, p.parseInfixExpression)
+	p.registerInflix(token.BIT_OR, p.parseInfixExpression)
+	p.registerInflix(token.BIT_XOR, p.parseInfixExpression)
+	p.registerInflix(token.SHL, p.parseInfixExpression)
+	p.registerInflix(token.SHR, p.parseInfixExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression) // IF
 	p.registerPrefix(token.TRUE, p.parseBoolean)    // bools
 	p.registerPrefix(token.FALSE, p.parseBoolean)
+	p.registerPrefix(token.NULL, p.parseNullLiteral)
+	p.registerPrefix(token.QUOTE, p.parseQuote)
+	p.registerPrefix(token.UNQUOTE, p.parseQuote)
+	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
+	p.registerInflix(token.LPAREN, p.parseCallExpression)
+	p.registerInflix(token.PLUS_ASSIGN, p.parseCompoundAssign)
+	p.registerInflix(token.MINUS_ASSIGN, p.parseCompoundAssign)
+	p.registerInflix(token.ASTERISK_ASSIGN, p.parseCompoundAssign)
+	p.registerInflix(token.SLASH_ASSIGN, p.parseCompoundAssign)
+	p.registerInflix(token.QUESTION, p.parseTernaryExpression)
+	p.registerInflix(token.DOTDOT, p.parseRangeExpression)
+	p.registerInflix(token.DOT, p.parseMemberExpression)
+	p.registerInflix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInflix(token.PIPE, p.parsePipeExpression)
+	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.RAW_STRING, p.parseStringLiteral)
+	p.registerPrefix(token.SLASH, p.parseRegexLiteral)
+	p.registerPrefix(token.SLASH_ASSIGN, p.parseRegexLiteral)
+	p.registerPrefix(token.SHL, p.parseHeredoc)
+	p.registerPrefix(token.STRING_HEAD, p.parseInterpolatedString)
+	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 
 	p.nextToken() // advance both current and peek
 	p.nextToken()
 	return p
 }
 
-// Errors returns the error array of strings.
-func (p *Parser) Errors() []string {
-	return p.errors
+// NewFromReader returns a Parser reading its input from r, configured by opts.
+// See lexer.NewFromReader.
+func NewFromReader(r io.Reader, opts ...Option) *Parser {
+	return New(lexer.NewFromReader(r), opts...)
 }
 
 // peekError appends an error msg to the errors array.
@@ -123,48 +331,87 @@ func (p *Parser) Errors() []string {
 //
 // parseStatement reads the curToken type and proceeds accordingly.
 func (p *Parser) parseStatement() ast.Statement {
-	switch p.curToken.Type {
-	case token.LET:
-		return p.parseLetStatement()
-	case token.RETURN:
-		return p.parseReturnStatement()
-	default:
-		return p.parseExpressionStatement()
+	leading := p.leadingComments()
+	var stmt ast.Statement
+	if fn := p.statementParseFns[p.curToken.Type]; fn != nil {
+		stmt = fn()
+	} else {
+		stmt = p.parseExpressionStatement()
+	}
+	if p.strictSemicolons && stmt != nil && !p.curTokenIs(token.SEMICOLON) && !endsInBlock(stmt) {
+		p.peekError(token.SEMICOLON)
 	}
+	if stmt != nil && p.keepComments {
+		p.attachComments(stmt, leading)
+	}
+	return stmt
 }
 
-// TODO
-func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
-	//	defer untrace(trace("parseExpressionStatement"))
+// endsInBlock reports whether stmt ends with a block, as if, while and fn
+// declarations do. Those need no semicolon even WithStrictSemicolons.
+func endsInBlock(stmt ast.Statement) bool {
+	switch s := stmt.(type) {
+	case *ast.FunctionStatement, *ast.ClassStatement, *ast.StructStatement,
+		*ast.WhileStatement, *ast.ForStatement, *ast.ForInStatement:
+		return true
+	case *ast.ExpressionStatement:
+		_, ok := s.Expression.(*ast.IfExpression)
+		return ok
+	}
+	return false
+}
+
+// parseExpressionStatement parses an expression used as a statement. It
+// returns nil when the expression could not be parsed, after skipping its
+// semicolon so the parse resumes at the next statement.
+func (p *Parser) parseExpressionStatement() ast.Statement {
+	defer p.untrace(p.trace("parseExpressionStatement"))
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 
 	stmt.Expression = p.parseExpression(LOWEST) // 0 precedence.
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
+	if stmt.Expression == nil {
+		return nil
+	}
 	return stmt
 
 }
 
-// parseExpression
+// parseExpression parses an expression whose operators bind tighter than
+// precedence. It returns nil if the expression is malformed; the parse
+// function that gave up has recorded why, so callers only need to pass the nil
+// on instead of building a node around it.
 func (p *Parser) parseExpression(precedence int) ast.Expression {
 
-	//	defer untrace(trace("parseExpression"))
+	defer p.untrace(p.trace("parseExpression"))
+	p.enter()
+	defer p.leave()
+
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
-		fmt.Println("err no prefix found :", prefix)
-		p.noPrefixParseFnError(p.curToken.Type)
+		p.noPrefixParseFnError(p.curToken)
 		return nil
 	}
 	left := prefix()
+	if left == nil {
+		return nil
+	}
 
 	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
+		if p.peekToken.LineStart && startsStatement[p.peekToken.Type] && !p.inBrackets() {
+			return left
+		}
 		infix := p.inflixParseFns[p.peekToken.Type]
 		if infix == nil {
 			return left
 		}
 		p.nextToken()
 		left = infix(left)
+		if left == nil {
+			return nil
+		}
 	}
 
 	return left
@@ -172,100 +419,198 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 
 func (p *Parser) parsePrefixExpression() ast.Expression {
 
-	//	defer untrace(trace("parsePrefixExpression"))
+	defer p.untrace(p.trace("parsePrefixExpression"))
 	expression := &ast.PrefixExpression{
 		Token:    p.curToken,
 		Operator: p.curToken.Literal,
 	}
 	p.nextToken()
 	expression.Right = p.parseExpression(PREFIX)
+	if expression.Right == nil {
+		return nil
+	}
 	return expression
 }
 
 // parseInfixExpression takes Left expression to constdruct an infix expression node with it. Then it assigns the precedence of the current token (operator of the infix expression) to the local var precedence.
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 
-	//	defer untrace(trace("parseInfixExpression"))
+	defer p.untrace(p.trace("parseInfixExpression"))
 	expression := &ast.InfixExpression{
 		Token:    p.curToken,
 		Operator: p.curToken.Literal,
 		Left:     left,
 	}
 	precedence := p.curPrecedence()
+	if p.rightAssoc[p.curToken.Type] {
+		precedence-- // let an operator of the same precedence bind the right side
+	}
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
-	//fmt.Printf(" Operator: %s   Left: %q  Right: %q\n", expression.Operator, expression.Left.String(), expression.Right.String())
+	if expression.Right == nil {
+		return nil
+	}
+	if precedence == LESSGREATER && p.precedences[p.peekToken.Type] == LESSGREATER {
+		// 1 < 2 < 3 would compare the boolean 1 < 2 with 3
+		p.addError(CodeChainedComparison, p.peekToken, expression)
+	}
 	return expression
 }
 
 // parseLetStatement parses a let statement inside parseStatment switch.
-func (p *Parser) parseLetStatement() *ast.LetStatement {
+func (p *Parser) parseLetStatement() ast.Statement {
 
-	//	defer untrace(trace("parseLetStatment"))
+	defer p.untrace(p.trace("parseLetStatement"))
 	stmt := &ast.LetStatement{Token: p.curToken}
 	if !p.expectPeek(token.IDENT) {
 		return nil
 	}
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if p.peekTokenIs(token.COMMA) {
+		stmt.Names = []*ast.Identifier{stmt.Name}
+		for p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			stmt.Names = append(stmt.Names, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		}
+	}
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
 	}
-	// TODO skipping the expressions until we encounter a semicolon
-	if !p.curTokenIs(token.SEMICOLON) {
+	p.nextToken()
+
+	stmt.Value = p.parseValueList()
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
+	if stmt.Value == nil {
+		return nil
+	}
 	return stmt
 }
 
-func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
+func (p *Parser) parseReturnStatement() ast.Statement {
 
-	//	defer untrace(trace("parseReturnStatement"))
+	defer p.untrace(p.trace("parseReturnStatement"))
 	stmt := &ast.ReturnStatement{Token: p.curToken}
 
+	// a bare return has no value
+	if p.peekTokenIs(token.SEMICOLON) || p.peekTokenIs(token.RBRACE) || p.peekTokenIs(token.EOF) {
+		if p.peekTokenIs(token.SEMICOLON) {
+			p.nextToken()
+		}
+		return stmt
+	}
 	p.nextToken()
-	//      TODO skipping until we meet ; .
 
-	for !p.curTokenIs(token.SEMICOLON) {
+	stmt.ReturnValue = p.parseValueList()
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
+	if stmt.ReturnValue == nil {
+		return nil
+	}
 	return stmt
 }
 
+// parseValueList parses the value of a let or return. A comma-separated list
+// such as return a, b; becomes a TupleLiteral.
+func (p *Parser) parseValueList() ast.Expression {
+	defer p.untrace(p.trace("parseValueList"))
+	value := p.parseExpression(LOWEST)
+	if value == nil || !p.peekTokenIs(token.COMMA) {
+		return value
+	}
+	tuple := &ast.TupleLiteral{Token: p.peekToken, Elements: []ast.Expression{value}}
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		p.nextToken()
+		element := p.parseExpression(LOWEST)
+		if element == nil {
+			return nil
+		}
+		tuple.Elements = append(tuple.Elements, element)
+	}
+	return tuple
+}
+
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 
-	//	defer untrace(trace("parseIntegerLiteral"))
+	defer p.untrace(p.trace("parseIntegerLiteral"))
 	literal := &ast.IntegerLiteral{Token: p.curToken}
 
 	out, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("failed to parse %q to integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(CodeBadInteger, p.curToken)
 	}
 	literal.Value = out
 	return literal
 }
 
 func (p *Parser) parseBoolean() ast.Expression {
+	defer p.untrace(p.trace("parseBoolean"))
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
+
+func (p *Parser) parseNullLiteral() ast.Expression {
+	defer p.untrace(p.trace("parseNullLiteral"))
+	return &ast.NullLiteral{Token: p.curToken}
+}
+
+// parseGroupedExpression parses (exp), or a tuple literal such as (1, "a") once
+// a comma follows the first element. A trailing comma is allowed, so (x,) is a
+// tuple of one.
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	defer p.untrace(p.trace("parseGroupedExpression"))
+	tok := p.curToken
 	p.nextToken()
 
 	exp := p.parseExpression(LOWEST)
+	if exp == nil {
+		return nil
+	}
+	if !p.peekTokenIs(token.COMMA) {
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+		return exp
+	}
+
+	tuple := &ast.TupleLiteral{Token: tok, Elements: []ast.Expression{exp}}
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if p.peekTokenIs(token.RPAREN) {
+			break
+		}
+		p.nextToken()
+		element := p.parseExpression(LOWEST)
+		if element == nil {
+			return nil
+		}
+		tuple.Elements = append(tuple.Elements, element)
+	}
 	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
-	return exp
+	tuple.Rparen = p.curToken
+	return tuple
 }
 
 // IF
 func (p *Parser) parseIfExpression() ast.Expression {
+	defer p.untrace(p.trace("parseIfExpression"))
 	expression := &ast.IfExpression{Token: p.curToken}
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
 	p.nextToken()
 	expression.Condition = p.parseExpression(LOWEST)
+	if expression.Condition == nil {
+		return nil
+	}
+	p.warnAssignCondition(expression.Condition)
 	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
@@ -273,19 +618,49 @@ func (p *Parser) parseIfExpression() ast.Expression {
 		return nil
 	}
 	expression.Consequence = p.parseBlockStatement()
+	p.warnEmptyBlock(expression.Consequence, "if")
 
 	if p.peekTokenIs(token.ELSE) {
 		p.nextToken()
+		if p.peekTokenIs(token.IF) {
+			expression.Alternative = p.parseElseIf()
+			if expression.Alternative == nil {
+				return nil
+			}
+			return expression
+		}
 		if !p.expectPeek(token.LBRACE) {
 			return nil
 		}
 		expression.Alternative = p.parseBlockStatement()
+		p.warnEmptyBlock(expression.Alternative, "else")
 	}
 
 	return expression
 }
 
+// parseElseIf parses the "if" following an else. The nested IfExpression is
+// wrapped in a block of its own, so else-if chains need no new node type.
+func (p *Parser) parseElseIf() *ast.BlockStatement {
+	defer p.untrace(p.trace("parseElseIf"))
+	p.nextToken()
+	tok := p.curToken
+	nested := p.parseIfExpression()
+	if nested == nil {
+		return nil
+	}
+	return &ast.BlockStatement{
+		Token:      tok,
+		Statements: []ast.Statement{&ast.ExpressionStatement{Token: tok, Expression: nested}},
+	}
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
+	defer p.untrace(p.trace("parseBlockStatement"))
+	// statements nest through blocks without a parseExpression between
+	// them, as in while (x) { while (x) { ... } }, so blocks count too
+	p.enter()
+	defer p.leave()
 
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
@@ -299,53 +674,790 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		}
 		p.nextToken()
 	}
+	if p.curTokenIs(token.RBRACE) {
+		block.Rbrace = p.curToken
+	} else {
+		p.addError(CodeUnexpectedToken, p.curToken).Expected = token.RBRACE
+	}
 	return block
 
 }
 
 // ERROR util
-func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+func (p *Parser) noPrefixParseFnError(t token.Token) {
+	p.addError(CodeNoPrefixParseFn, t)
 }
 
 // curTokenIs checks if the current token is a specified token.Type.
 
+// parseFunctionStatement parses a named declaration, fn name(params) { body }.
+// Without a name the fn starts an expression statement as before, so
+// fn(x) { x }(1) still works.
+func (p *Parser) parseFunctionStatement() ast.Statement {
+	defer p.untrace(p.trace("parseFunctionStatement"))
+	if !p.peekTokenIs(token.IDENT) {
+		return p.parseExpressionStatement()
+	}
+	fnTok := p.curToken
+	p.nextToken()
+	if stmt := p.parseNamedFunction(fnTok); stmt != nil {
+		return stmt
+	}
+	return nil
+}
+
+// parseNamedFunction parses the name(params) { body } following fnTok, with
+// the current token at the name.
+func (p *Parser) parseNamedFunction(fnTok token.Token) *ast.FunctionStatement {
+	defer p.untrace(p.trace("parseNamedFunction"))
+	stmt := &ast.FunctionStatement{Token: fnTok}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	// the rest reads like a literal with the name standing in for fn
+	fn, ok := p.parseFunctionLiteral().(*ast.FunctionLiteral)
+	if !ok {
+		return nil
+	}
+	fn.Token = stmt.Token
+	stmt.Function = fn
+	return stmt
+}
+
+// parseClassStatement parses class Name { fn method(params) { body } ... },
+// where a method may also define an operator, see parseOperatorMethod.
+func (p *Parser) parseClassStatement() ast.Statement {
+	defer p.untrace(p.trace("parseClassStatement"))
+	stmt := &ast.ClassStatement{Token: p.curToken, Methods: []*ast.FunctionStatement{}}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.FUNCTION) {
+			return nil
+		}
+		fnTok := p.curToken
+		leading := p.leadingComments()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		if p.curToken.Literal == "operator" && !p.peekTokenIs(token.LPAREN) {
+			op := p.parseOperatorMethod(fnTok)
+			if op == nil {
+				return nil
+			}
+			stmt.Operators = append(stmt.Operators, op)
+			continue
+		}
+		method := p.parseNamedFunction(fnTok)
+		if method == nil {
+			return nil
+		}
+		if p.keepComments {
+			p.attachComments(method, leading)
+		}
+		stmt.Methods = append(stmt.Methods, method)
+	}
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	stmt.Rbrace = p.curToken
+	return stmt
+}
+
 func (p *Parser) parseFunctionLiteral() ast.Expression {
+	defer p.untrace(p.trace("parseFunctionLiteral"))
 
 	ft := &ast.FunctionLiteral{Token: p.curToken}
 	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
-	ft.Parameters = p.parseFunctionParameters()
-	if !p.expectPeek(token.LBRACE) {
+	ft.Parameters, ft.Variadic = p.parseFunctionParameters()
+	if ft.Parameters == nil || !p.expectPeek(token.LBRACE) {
 		return nil
 	}
 
+	outer := p.yielded
+	yielded := false
+	p.yielded = &yielded
 	ft.Body = p.parseBlockStatement()
+	p.yielded = outer
+	ft.Generator = yielded
 	return ft
 }
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
+
+// parseFunctionParameters parses the parameter list of a function literal and
+// reports whether it ends in a variadic parameter, as in fn(x, rest...). The
+// parameters are nil if the list is malformed.
+func (p *Parser) parseFunctionParameters() ([]*ast.Identifier, bool) {
+	defer p.untrace(p.trace("parseFunctionParameters"))
 
 	identifiers := []*ast.Identifier{}
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return identifiers
+		return identifiers, false
+	}
+	if !p.expectPeek(token.IDENT) {
+		return nil, false
 	}
-	p.nextToken()
 
 	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	identifiers = append(identifiers, ident)
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
-		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil, false
+		}
 
 		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 		identifiers = append(identifiers, ident)
 	}
+	variadic := false
+	if p.peekTokenIs(token.ELLIPSIS) {
+		p.nextToken()
+		variadic = true // only the last parameter, so ) must follow
+	}
+	if !p.expectPeek(token.RPAREN) {
+		return nil, false
+	}
+	return identifiers, variadic
+}
+
+// parseMemberExpression parses object.property, and tuple.0 for tuple element
+// access. It binds tighter than calls, so a.b(c) calls a.b, and chains left to
+// right, so a.b.c is (a.b).c.
+func (p *Parser) parseMemberExpression(object ast.Expression) ast.Expression {
+	defer p.untrace(p.trace("parseMemberExpression"))
+	if p.peekTokenIs(token.INT) {
+		exp := &ast.TupleIndexExpression{Token: p.curToken, Tuple: object}
+		p.nextToken()
+		index, ok := p.parseIntegerLiteral().(*ast.IntegerLiteral)
+		if !ok {
+			return nil
+		}
+		exp.Index = index
+		return exp
+	}
+	exp := &ast.MemberExpression{Token: p.curToken, Object: object}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	exp.Property = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	return exp
+}
+
+// parseIndexExpression parses left[index] and the slices left[low:high],
+// left[:high] and left[low:], where either bound may be left out.
+func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+	defer p.untrace(p.trace("parseIndexExpression"))
+	tok := p.curToken
+	p.nextToken()
+
+	var low ast.Expression
+	if !p.curTokenIs(token.COLON) {
+		low = p.parseExpression(LOWEST)
+		if low == nil {
+			return nil
+		}
+		if p.peekTokenIs(token.RBRACKET) {
+			p.nextToken()
+			return &ast.IndexExpression{Token: tok, Left: left, Index: low, Rbracket: p.curToken}
+		}
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+	}
+
+	slice := &ast.SliceExpression{Token: tok, Left: left, Low: low}
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		slice.High = p.parseExpression(LOWEST)
+		if slice.High == nil {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	slice.Rbracket = p.curToken
+	return slice
+}
+
+// parseCallExpression parses the argument list following function, e.g. add(1, 2).
+// A method call such as s.trim() is a call whose function is a MemberExpression.
+func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
+	defer p.untrace(p.trace("parseCallExpression"))
+	exp := &ast.CallExpression{Token: p.curToken, Function: function}
+	exp.Arguments = p.parseCallArguments()
+	if exp.Arguments == nil {
+		return nil
+	}
+	exp.Rparen = p.curToken
+	return exp
+}
+
+func (p *Parser) parseCallArguments() []ast.Expression {
+	defer p.untrace(p.trace("parseCallArguments"))
+	args := []ast.Expression{}
+
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		return args
+	}
+	p.nextToken()
+	arg := p.parseCallArgument()
+	if arg == nil {
+		return nil
+	}
+	args = append(args, arg)
+
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		p.nextToken()
+		if arg = p.parseCallArgument(); arg == nil {
+			return nil
+		}
+		args = append(args, arg)
+	}
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	return args
+}
+
+// parseCallArgument parses one argument, which is either an expression or a
+// named argument such as x: 1.
+func (p *Parser) parseCallArgument() ast.Expression {
+	defer p.untrace(p.trace("parseCallArgument"))
+	if !p.curTokenIs(token.IDENT) || !p.peekTokenIs(token.COLON) {
+		return p.parseExpression(LOWEST)
+	}
+	name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	arg := &ast.NamedArgument{Token: p.curToken, Name: name}
+	p.nextToken()
+	p.nextToken()
+	arg.Value = p.parseExpression(LOWEST)
+	if arg.Value == nil {
+		return nil
+	}
+	return arg
+}
+
+func (p *Parser) parseStringLiteral() ast.Expression {
+	defer p.untrace(p.trace("parseStringLiteral"))
+	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+}
+
+// parseQuote parses the special forms quote(exp) and unquote(exp). Their
+// argument is kept as syntax, for macro expansion to work on.
+func (p *Parser) parseQuote() ast.Expression {
+	defer p.untrace(p.trace("parseQuote"))
+	tok := p.curToken
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	node := p.parseExpression(LOWEST)
+	if node == nil || !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if tok.Type == token.UNQUOTE {
+		return &ast.UnquoteExpression{Token: tok, Node: node, Rparen: p.curToken}
+	}
+	return &ast.QuoteExpression{Token: tok, Node: node, Rparen: p.curToken}
+}
+
+// parseMacroLiteral parses macro(params) { body }, whose params cannot be
+// variadic. Without FeatureMacros macro is an identifier and never gets here,
+// see nextToken. Macros are expanded before evaluation, see package macro.
+func (p *Parser) parseMacroLiteral() ast.Expression {
+	defer p.untrace(p.trace("parseMacroLiteral"))
+	lit := &ast.MacroLiteral{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	params, variadic := p.parseFunctionParameters()
+	if variadic {
+		p.addError(CodeVariadicMacro, params[len(params)-1].Token)
+	}
+	lit.Parameters = params
+	if lit.Parameters == nil || !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	lit.Body = p.parseBlockStatement()
+	return lit
+}
+
+// parseRegexLiteral parses /pattern/flags. A slash only reaches the prefix
+// table where an operand is expected, so the lexer is asked to rescan the
+// input from it as a regex; the token already read past it is dropped.
+func (p *Parser) parseRegexLiteral() ast.Expression {
+	defer p.untrace(p.trace("parseRegexLiteral"))
+	p.rescan(p.l.ScanRegex)
+	if !p.curTokenIs(token.REGEX) {
+		return nil // the lexer reported it
+	}
+	lit := &ast.RegexLiteral{Token: p.curToken}
+	end := strings.LastIndexByte(lit.Token.Literal, '/')
+	lit.Pattern = lit.Token.Literal[1:end]
+	lit.Flags = lit.Token.Literal[end+1:]
+	return lit
+}
+
+// parseHeredoc parses a <<TAG heredoc into a StringLiteral, rescanning the
+// input the same way parseRegexLiteral does.
+func (p *Parser) parseHeredoc() ast.Expression {
+	defer p.untrace(p.trace("parseHeredoc"))
+	p.rescan(p.l.ScanHeredoc)
+	if !p.curTokenIs(token.STRING) {
+		return nil // the lexer reported it
+	}
+	return p.parseStringLiteral()
+}
+
+// parseInterpolatedString parses "text ${expr} text". The lexer splits the
+// string into STRING_HEAD, STRING_MID and STRING_TAIL pieces around the tokens
+// of each expression, so every ${...} may hold any expression.
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	defer p.untrace(p.trace("parseInterpolatedString"))
+	str := &ast.InterpolatedString{Token: p.curToken}
+	for {
+		if p.curToken.Literal != "" {
+			str.Parts = append(str.Parts, p.parseStringLiteral())
+		}
+		if p.curTokenIs(token.STRING_TAIL) {
+			str.Tail = p.curToken
+			return str
+		}
+		p.nextToken()
+		part := p.parseExpression(LOWEST)
+		if part == nil {
+			return nil
+		}
+		str.Parts = append(str.Parts, part)
+		if p.peekTokenIs(token.STRING_MID) {
+			p.nextToken()
+		} else if !p.expectPeek(token.STRING_TAIL) {
+			return nil
+		}
+	}
+}
+
+// parseHashLiteral parses {key: value, ...}. Blocks are only parsed after if
+// and fn, so a { reaching the prefix table always starts a hash literal.
+func (p *Parser) parseHashLiteral() ast.Expression {
+	defer p.untrace(p.trace("parseHashLiteral"))
+	hash := &ast.HashLiteral{Token: p.curToken, Pairs: []ast.HashPair{}}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		key := p.parseExpression(LOWEST)
+		if key == nil || !p.expectPeek(token.COLON) {
+			return nil
+		}
+		p.nextToken()
+		value := p.parseExpression(LOWEST)
+		if value == nil {
+			return nil
+		}
+		hash.Pairs = append(hash.Pairs, ast.HashPair{Key: key, Value: value})
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	hash.Rbrace = p.curToken
+	return hash
+}
+
+// overloadable marks the operators a class can define with fn operator.
+var overloadable = [256]bool{
+	token.PLUS:     true,
+	token.MINUS:    true,
+	token.ASTERISK: true,
+	token.SLASH:    true,
+	token.POWER:    true,
+	token.EQ:       true,
+	token.NOT_EQ:   true,
+	token.LT:       true,
+	token.GT:       true,
+	token.BANG:     true,
+	token.BIT_AND:  true,
+	token.BIT_OR:   true,
+	token.BIT_XOR:  true,
+	token.BIT_NOT:  true,
+	token.SHL:      true,
+	token.SHR:      true,
+}
+
+// parseOperatorMethod parses the operator +(params) { body } of a class method
+// following fnTok, with the current token at "operator". "operator" is only
+// special when an operator follows, so a method can still be named operator.
+func (p *Parser) parseOperatorMethod(fnTok token.Token) *ast.OperatorMethod {
+	defer p.untrace(p.trace("parseOperatorMethod"))
+	p.nextToken()
+	op := &ast.OperatorMethod{Token: fnTok, Operator: p.curToken.Literal}
+	if !overloadable[p.curToken.Type] {
+		p.addError(CodeNotOverloadable, p.curToken)
+		return nil
+	}
+	fn, ok := p.parseFunctionLiteral().(*ast.FunctionLiteral)
+	if !ok {
+		return nil
+	}
+	fn.Token = fnTok
+	op.Function = fn
+	return op
+}
+
+// parseAssertStatement parses assert condition; with an optional message,
+// assert condition, "message";.
+func (p *Parser) parseAssertStatement() ast.Statement {
+	defer p.untrace(p.trace("parseAssertStatement"))
+	stmt := &ast.AssertStatement{Token: p.curToken}
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+	if stmt.Condition == nil {
+		return nil
+	}
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		p.nextToken()
+		if stmt.Message = p.parseExpression(LOWEST); stmt.Message == nil {
+			return nil
+		}
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// parseYieldStatement parses yield value; and marks the enclosing function
+// literal as a generator.
+func (p *Parser) parseYieldStatement() ast.Statement {
+	defer p.untrace(p.trace("parseYieldStatement"))
+	stmt := &ast.YieldStatement{Token: p.curToken}
+	if p.yielded == nil {
+		p.addError(CodeYieldOutsideFunction, p.curToken)
+	} else {
+		*p.yielded = true
+	}
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	if stmt.Value == nil {
+		return nil
+	}
+	return stmt
+}
+
+// parseStructStatement parses struct Name { field, ... }.
+func (p *Parser) parseStructStatement() ast.Statement {
+	defer p.untrace(p.trace("parseStructStatement"))
+	stmt := &ast.StructStatement{Token: p.curToken, Fields: []*ast.Identifier{}}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Fields = append(stmt.Fields, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	stmt.Rbrace = p.curToken
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// parseStructLiteral parses Name{field: value, ...}. It is reached from
+// parseIdentifier when a { follows the name on the same line. A { starting
+// the next line begins a statement instead, as in
+//
+//	let a = b
+//	{"k": 1}
+//
+// unless it is inside ( ) or [ ], where newlines never end a statement.
+func (p *Parser) parseStructLiteral(name *ast.Identifier) ast.Expression {
+	defer p.untrace(p.trace("parseStructLiteral"))
+	p.nextToken()
+	lit := &ast.StructLiteral{Token: p.curToken, Type: name, Fields: []ast.StructField{}}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		field := ast.StructField{Name: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+		p.nextToken()
+		field.Value = p.parseExpression(LOWEST)
+		if field.Value == nil {
+			return nil
+		}
+		lit.Fields = append(lit.Fields, field)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	lit.Rbrace = p.curToken
+	return lit
+}
+
+// parseWhileStatement parses while (condition) { body }.
+func (p *Parser) parseWhileStatement() ast.Statement {
+	defer p.untrace(p.trace("parseWhileStatement"))
+	stmt := &ast.WhileStatement{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+	if stmt.Condition == nil {
+		return nil
+	}
+	p.warnAssignCondition(stmt.Condition)
 	if !p.expectPeek(token.RPAREN) {
 		return nil
 	}
-	return identifiers
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+	p.warnEmptyBlock(stmt.Body, "while")
+	return stmt
+}
+
+// parseImportStatement parses import "path" as alias; where the alias is
+// optional. "as" is only special here, so it stays usable as a name.
+func (p *Parser) parseImportStatement() ast.Statement {
+	defer p.untrace(p.trace("parseImportStatement"))
+	stmt := &ast.ImportStatement{Token: p.curToken}
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	stmt.Path = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	if p.peekTokenIs(token.IDENT) && p.peekToken.Literal == "as" {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Alias = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// parseDoWhileStatement parses do { body } while (condition);.
+func (p *Parser) parseDoWhileStatement() ast.Statement {
+	defer p.untrace(p.trace("parseDoWhileStatement"))
+	stmt := &ast.DoWhileStatement{Token: p.curToken}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+	p.warnEmptyBlock(stmt.Body, "do")
+	if !p.expectPeek(token.WHILE) || !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+	if stmt.Condition == nil {
+		return nil
+	}
+	p.warnAssignCondition(stmt.Condition)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	stmt.Rparen = p.curToken
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+// parseForStatement parses for (init; condition; post) { body }, where any of
+// the three clauses may be left empty, and for (x in iterable) { body }.
+func (p *Parser) parseForStatement() ast.Statement {
+	defer p.untrace(p.trace("parseForStatement"))
+	stmt := &ast.ForStatement{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	if p.curTokenIs(token.IDENT) && p.peekTokenIs(token.IN) {
+		return p.parseForInStatement(stmt.Token)
+	}
+	if !p.curTokenIs(token.SEMICOLON) {
+		if stmt.Init = p.parseSimpleStatement(); stmt.Init == nil {
+			return nil
+		}
+		// let and expression statements consume their own semicolon
+		if !p.curTokenIs(token.SEMICOLON) && !p.expectPeek(token.SEMICOLON) {
+			return nil
+		}
+	}
+
+	if !p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+		stmt.Condition = p.parseExpression(LOWEST)
+		if stmt.Condition == nil {
+			return nil
+		}
+		p.warnAssignCondition(stmt.Condition)
+	}
+	if !p.expectPeek(token.SEMICOLON) {
+		return nil
+	}
+
+	if !p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		if stmt.Post = p.parseSimpleStatement(); stmt.Post == nil {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+	p.warnEmptyBlock(stmt.Body, "for")
+	return stmt
+}
+
+// parseForInStatement parses the rest of for (x in iterable) { body } with
+// the binding identifier as the current token.
+func (p *Parser) parseForInStatement(forToken token.Token) ast.Statement {
+	defer p.untrace(p.trace("parseForInStatement"))
+	stmt := &ast.ForInStatement{Token: forToken}
+	stmt.Binding = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	p.nextToken() // in
+	p.nextToken()
+
+	stmt.Iterable = p.parseExpression(LOWEST)
+	if stmt.Iterable == nil || !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+	p.warnEmptyBlock(stmt.Body, "for")
+	return stmt
+}
+
+// parseSimpleStatement parses the let or expression statements allowed in a
+// for loop header.
+func (p *Parser) parseSimpleStatement() ast.Statement {
+	if p.curTokenIs(token.LET) {
+		return p.parseLetStatement()
+	}
+	return p.parseExpressionStatement()
+}
+
+// parseCompoundAssign parses target op= value. It is right-associative, so
+// a += b -= 1 groups as (a += (b -= 1)).
+func (p *Parser) parseCompoundAssign(target ast.Expression) ast.Expression {
+	defer p.untrace(p.trace("parseCompoundAssign"))
+	exp := &ast.CompoundAssign{
+		Token:    p.curToken,
+		Target:   target,
+		Operator: p.curToken.Literal,
+	}
+	if _, ok := target.(*ast.Identifier); !ok {
+		p.addError(CodeInvalidAssignTarget, p.curToken)
+	}
+	p.nextToken()
+	exp.Value = p.parseExpression(ASSIGN - 1)
+	if exp.Value == nil {
+		return nil
+	}
+	return exp
+}
+
+// parseTernaryExpression parses condition ? consequence : alternative. It is
+// right-associative, so a ? b : c ? d : e groups as a ? b : (c ? d : e).
+func (p *Parser) parseTernaryExpression(condition ast.Expression) ast.Expression {
+	defer p.untrace(p.trace("parseTernaryExpression"))
+	exp := &ast.TernaryExpression{Token: p.curToken, Condition: condition}
+
+	p.nextToken()
+	exp.Consequence = p.parseExpression(LOWEST)
+	if exp.Consequence == nil || !p.expectPeek(token.COLON) {
+		return nil
+	}
+	p.nextToken()
+	exp.Alternative = p.parseExpression(TERNARY - 1)
+	if exp.Alternative == nil {
+		return nil
+	}
+	return exp
+}
+
+// parsePipeExpression parses value |> f. Pipes bind looser than everything but
+// ternaries and assignment and are left-associative, so a |> f |> g is
+// (a |> f) |> g. They require FeaturePipes.
+func (p *Parser) parsePipeExpression(value ast.Expression) ast.Expression {
+	defer p.untrace(p.trace("parsePipeExpression"))
+	exp := &ast.PipeExpression{Token: p.curToken, Value: value}
+	p.requireFeature(FeaturePipes, p.curToken)
+
+	p.nextToken()
+	exp.Function = p.parseExpression(PIPE)
+	if exp.Function == nil {
+		return nil
+	}
+	return exp
+}
+
+// parseRangeExpression parses start..end with an optional ..step, e.g.
+// 1..10 or 0..n..2.
+func (p *Parser) parseRangeExpression(start ast.Expression) ast.Expression {
+	defer p.untrace(p.trace("parseRangeExpression"))
+	exp := &ast.RangeExpression{Token: p.curToken, Start: start}
+
+	p.nextToken()
+	exp.Stop = p.parseExpression(RANGE)
+	if exp.Stop == nil {
+		return nil
+	}
+	if p.peekTokenIs(token.DOTDOT) {
+		p.nextToken()
+		p.nextToken()
+		if exp.Step = p.parseExpression(RANGE); exp.Step == nil {
+			return nil
+		}
+	}
+	return exp
 }
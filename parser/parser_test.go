@@ -1,10 +1,18 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"interpreter/ast"
 	"interpreter/lexer"
+	"interpreter/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
 )
 
 /// HELPOER
@@ -271,6 +279,123 @@ return test;
 	}
 }
 
+func TestMultipleValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		names    []string // nil for a single name
+		elements []string // nil for a single value
+		expected string
+	}{
+		{"return a, b;", nil, []string{"a", "b"}, "return (a, b);"},
+		{"return 1, x + 1, f(y);", nil, []string{"1", "(x + 1)", "f(y)"}, "return (1, (x + 1), f(y));"},
+		{"let x, y = f();", []string{"x", "y"}, nil, "let x, y = f();"},
+		{"let q, r, ok = 7, 2, true;", []string{"q", "r", "ok"}, []string{"7", "2", "true"}, "let q, r, ok = (7, 2, true);"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("%q: program.Statements does not contain 1 statement. got=%d", tt.input, len(program.Statements))
+		}
+		var value ast.Expression
+		switch stmt := program.Statements[0].(type) {
+		case *ast.ReturnStatement:
+			value = stmt.ReturnValue
+		case *ast.LetStatement:
+			value = stmt.Value
+			if len(stmt.Names) != len(tt.names) {
+				t.Fatalf("%q: wrong number of names. want=%d, got=%d", tt.input, len(tt.names), len(stmt.Names))
+			}
+			for i, name := range tt.names {
+				testIdentifier(t, stmt.Names[i], name)
+			}
+			testIdentifier(t, stmt.Name, tt.names[0])
+		}
+		if tt.elements != nil {
+			tuple, ok := value.(*ast.TupleLiteral)
+			if !ok {
+				t.Fatalf("%q: value is not ast.TupleLiteral. got=%T", tt.input, value)
+			}
+			if len(tuple.Elements) != len(tt.elements) {
+				t.Fatalf("%q: wrong number of elements. want=%d, got=%d", tt.input, len(tt.elements), len(tuple.Elements))
+			}
+			for i, want := range tt.elements {
+				if tuple.Elements[i].String() != want {
+					t.Errorf("%q: element %d wrong. want=%q, got=%q", tt.input, i, want, tuple.Elements[i].String())
+				}
+			}
+		}
+		if got := program.String(); got != tt.expected {
+			t.Errorf("program.String() wrong. want=%q, got=%q", tt.expected, got)
+		}
+	}
+
+	for _, input := range []string{"let x, = 1;", "let x, 2 = 1;", "return a, ;"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected errors", input)
+		}
+	}
+}
+
+func TestTupleLiteral(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`(1, "a", true);`, []string{"1", "a", "true"}},
+		{"(x,);", []string{"x"}},
+		{"(x, y + 1,);", []string{"x", "(y + 1)"}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		tuple, ok := stmt.Expression.(*ast.TupleLiteral)
+		if !ok {
+			t.Fatalf("%q: exp not *ast.TupleLiteral. got=%T", tt.input, stmt.Expression)
+		}
+		if len(tuple.Elements) != len(tt.expected) {
+			t.Fatalf("%q: wrong number of elements. want=%d, got=%d", tt.input, len(tt.expected), len(tuple.Elements))
+		}
+		for i, want := range tt.expected {
+			if tuple.Elements[i].String() != want {
+				t.Errorf("%q: element %d wrong. want=%q, got=%q", tt.input, i, want, tuple.Elements[i].String())
+			}
+		}
+	}
+
+	p := New(lexer.New("(x);"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	testIdentifier(t, program.Statements[0].(*ast.ExpressionStatement).Expression, "x")
+
+	p = New(lexer.New("pair.1;"))
+	program = p.ParseProgram()
+	checkParserErrors(t, p)
+	access, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.TupleIndexExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.TupleIndexExpression. got=%T", program.Statements[0].(*ast.ExpressionStatement).Expression)
+	}
+	testIdentifier(t, access.Tuple, "pair")
+	testIntegerLiteral(t, access.Index, 1)
+
+	for _, input := range []string{"(1, 2;", "(1,,);", "(,);"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected parser errors", input)
+		}
+	}
+}
+
 func TestInfixExpressions(t *testing.T) {
 	inflixTests := []struct {
 		input      string
@@ -286,6 +411,13 @@ func TestInfixExpressions(t *testing.T) {
 		{"5 > 5;", 5, ">", 5},
 		{"5 == 5;", 5, "==", 5},
 		{"5 / 5;", 5, "/", 5},
+		{"5 && 5;", 5, "&&", 5},
+		{"5 || 5;", 5, "||", 5},
+		{"5 & 5;", 5, "&", 5},
+		{"5 | 5;", 5, "|", 5},
+		{"5 ^ 5;", 5, "^", 5},
+		{"5 << 5;", 5, "<<", 5},
+		{"5 >> 5;", 5, ">>", 5},
 	}
 
 	for _, tt := range inflixTests {
@@ -319,11 +451,33 @@ func TestInfixExpressions(t *testing.T) {
 			return
 
 		}
+	}
+
+}
 
-		fmt.Printf(" Left: %v Right: %v Operator: %v \n", tt.leftValue, tt.rightValue, tt.operator)
+func TestChainedComparison(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 < 2 < 3;", `1:7: comparisons cannot be chained at "<" -- (1 < 2) would be compared with what follows, join comparisons with &&`},
+		{"a > b < c;", `1:7: comparisons cannot be chained at "<" -- (a > b) would be compared with what follows, join comparisons with &&`},
+		{"x + 1 < y * 2 > z;", `1:15: comparisons cannot be chained at ">" -- ((x + 1) < (y * 2)) would be compared with what follows, join comparisons with &&`},
+	}
 
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) != 1 || p.Errors()[0] != tt.expected {
+			t.Errorf("%q: wrong errors. got=%q", tt.input, p.Errors())
+		}
 	}
 
+	for _, input := range []string{"(1 < 2) < 3;", "1 < 2 == 2 < 3;", "a < b && b < c;", "a == b == c;"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		checkParserErrors(t, p)
+	}
 }
 
 func TestPrefixExpression(t *testing.T) {
@@ -333,6 +487,8 @@ func TestPrefixExpression(t *testing.T) {
 		integerValue int64
 	}{{"!5;", "!", 5},
 		{"-15;", "-", 15},
+		{"~15;", "~", 15},
+		{"+5;", "+", 5},
 	}
 
 	for i, tt := range prefixTests {
@@ -454,17 +610,233 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"!(true == true)",
 			"(!(true == true))",
 		},
+		{
+			"a + add(b * c) + d",
+			"((a + add((b * c))) + d)",
+		},
+		{
+			"add(a, b, 1, 2 * 3, 4 + 5, add(6, 7 * 8))",
+			"add(a,b,1,(2 * 3),(4 + 5),add(6,(7 * 8)))",
+		},
+		{
+			"add(a + b + c * d / f + g)",
+			"add((((a + b) + ((c * d) / f)) + g))",
+		},
+		{
+			"x += a * b",
+			"(x += (a * b))",
+		},
+		{
+			"x -= y == z",
+			"(x -= (y == z))",
+		},
+		{
+			"a += b -= c /= 2",
+			"(a += (b -= (c /= 2)))",
+		},
+		{
+			"x *= f(y) + 1",
+			"(x *= (f(y) + 1))",
+		},
+		{
+			"a > 1 && b < 2 || c == 3",
+			"(((a > 1) && (b < 2)) || (c == 3))",
+		},
+		{
+			"a || b && c",
+			"(a || (b && c))",
+		},
+		{
+			"a && b && c || d",
+			"(((a && b) && c) || d)",
+		},
+		{
+			"!a && b != c",
+			"((!a) && (b != c))",
+		},
+		{
+			"ok += a || b",
+			"(ok += (a || b))",
+		},
+		{
+			"a | b ^ c & d",
+			"(a | (b ^ (c & d)))",
+		},
+		{
+			"x & 1 == 0",
+			"((x & 1) == 0)",
+		},
+		{
+			"1 << n + 1",
+			"(1 << (n + 1))",
+		},
+		{
+			"a >> 2 << 1 & mask",
+			"(((a >> 2) << 1) & mask)",
+		},
+		{
+			"~a & b",
+			"((~a) & b)",
+		},
+		{
+			"a < b | c && d",
+			"((a < (b | c)) && d)",
+		},
+		{
+			"a ? b : c",
+			"(a ? b : c)",
+		},
+		{
+			"a ? b : c ? d : e",
+			"(a ? b : (c ? d : e))",
+		},
+		{
+			"x > 0 && y ? x + y : -x",
+			"(((x > 0) && y) ? (x + y) : (-x))",
+		},
+		{
+			"a ? b ? c : d : e",
+			"(a ? (b ? c : d) : e)",
+		},
+		{
+			"x += ok ? 1 : 2",
+			"(x += (ok ? 1 : 2))",
+		},
+		{
+			"f(a ? b : c, d)",
+			"f((a ? b : c),d)",
+		},
+		{
+			"1..10",
+			"(1 .. 10)",
+		},
+		{
+			"a + 1..n * 2",
+			"((a + 1) .. (n * 2))",
+		},
+		{
+			"0..n..2",
+			"(0 .. n .. 2)",
+		},
+		{
+			"1..10 == r",
+			"((1 .. 10) == r)",
+		},
+		{
+			"-1..len(xs) - 1",
+			"((-1) .. (len(xs) - 1))",
+		},
+		{
+			"a + b.c * d",
+			"(a + (b.c * d))",
+		},
+		{
+			"-p.x",
+			"(-p.x)",
+		},
+		{
+			"config.server.port == 80",
+			"(config.server.port == 80)",
+		},
+		{
+			"f(a.b).c",
+			"f(a.b).c",
+		},
+		{
+			"s.trim().len()",
+			"s.trim().len()",
+		},
+		{
+			"a.b(c).d(e + f) * 2",
+			"(a.b(c).d((e + f)) * 2)",
+		},
+		{
+			"!xs.empty()",
+			"(!xs.empty())",
+		},
+		{
+			"(1, a + b) == t",
+			"((1, (a + b)) == t)",
+		},
+		{
+			"pair.0 + pair.1 * 2",
+			"(pair.0 + (pair.1 * 2))",
+		},
+		{
+			"f((x,)).0.1",
+			"f((x,)).0.1",
+		},
+		{
+			"a * b[2] + c",
+			"((a * (b[2])) + c)",
+		},
+		{
+			"-xs[i + 1]",
+			"(-(xs[(i + 1)]))",
+		},
+		{
+			"m.rows[0].len()",
+			"(m.rows[0]).len()",
+		},
+		{
+			"f(x)[1:n - 1][0]",
+			"((f(x)[1:(n - 1)])[0])",
+		},
+		{
+			"+5",
+			"(+5)",
+		},
+		{
+			"--x",
+			"(-(-x))",
+		},
+		{
+			"!!x",
+			"(!(!x))",
+		},
+		{
+			"a - -b + +c",
+			"((a - (-b)) + (+c))",
+		},
+		{
+			"-+!~x * y",
+			"((-(+(!(~x)))) * y)",
+		},
+		{
+			"2 ** 3 ** 2",
+			"(2 ** (3 ** 2))",
+		},
+		{
+			"a * b ** c * d",
+			"((a * (b ** c)) * d)",
+		},
+		{
+			"-a ** 2",
+			"((-a) ** 2)",
+		},
+		{
+			"a ** f(b) ** c.d + 1",
+			"((a ** (f(b) ** c.d)) + 1)",
+		},
+		{
+			"data |> filter(f) |> map(g)",
+			"((data |> filter(f)) |> map(g))",
+		},
+		{
+			"a + 1 |> f || g",
+			"((a + 1) |> (f || g))",
+		},
+		{
+			"ok ? xs |> f : ys",
+			"(ok ? (xs |> f) : ys)",
+		},
 	}
 	for _, tt := range tests {
-		fmt.Println("Input: ", tt.input)
 		l := lexer.New(tt.input)
 		p := New(l)
 		program := p.ParseProgram()
 		checkParserErrors(t, p)
 		actual := program.String()
-
-		//fmt.Printf("Test: %q Actual %q -- Expected %q\n", tt.input, actual, tt.expected)
-		fmt.Println()
 		if actual != tt.expected {
 			t.Errorf("expected=%q, got=%q", tt.expected, actual)
 		}
@@ -530,9 +902,49 @@ func TestIfElseExpression(t *testing.T) {
 	}
 }
 
+func TestElseIfExpression(t *testing.T) {
+	input := `if (x < y) { x } else if (x > y) { y } else if (x == 0) { 0 } else { 1 }`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+	}
+	exp := program.Statements[0].(*ast.ExpressionStatement).Expression
+	for _, want := range []struct {
+		operator    string
+		consequence string
+	}{{"<", "x"}, {">", "y"}, {"==", "0"}} {
+		ifExp, ok := exp.(*ast.IfExpression)
+		if !ok {
+			t.Fatalf("exp is not ast.IfExpression. got=%T", exp)
+		}
+		if cond, ok := ifExp.Condition.(*ast.InfixExpression); !ok || cond.Operator != want.operator {
+			t.Fatalf("condition wrong. got=%s", ifExp.Condition)
+		}
+		if got := ifExp.Consequence.String(); got != want.consequence {
+			t.Errorf("consequence wrong. want=%q, got=%q", want.consequence, got)
+		}
+		if ifExp.Alternative == nil || len(ifExp.Alternative.Statements) != 1 {
+			t.Fatalf("alternative is not a single statement. got=%v", ifExp.Alternative)
+		}
+		exp = ifExp.Alternative.Statements[0].(*ast.ExpressionStatement).Expression
+	}
+	testIntegerLiteral(t, exp, 1)
+
+	for _, input := range []string{"if (a) { 1 } else if { 2 }", "if (a) { 1 } else if (b) 2"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected errors", input)
+		}
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := `fn(x,y){x + y};`
-	fmt.Println("test input: ", input)
 	l := lexer.New(input)
 	p := New(l)
 	program := p.ParseProgram()
@@ -599,33 +1011,2520 @@ func TestFunctionParameterParsing(t *testing.T) {
 	}
 }
 
-func TestCallExpressionParsing(t *testing.T) {
-	input := "add(1, 2 * 3, 4 + 5);"
-	l := lexer.New(input)
-	p := New(l)
+func TestVariadicParameters(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedParams []string
+		variadic       bool
+		expectedString string
+	}{
+		{"fn(x, rest...) {};", []string{"x", "rest"}, true, "fn(x,rest...)"},
+		{"fn(args...) {};", []string{"args"}, true, "fn(args...)"},
+		{"fn(x, y) {};", []string{"x", "y"}, false, "fn(x,y)"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		function := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+		if len(function.Parameters) != len(tt.expectedParams) {
+			t.Fatalf("length parameters wrong. want %d, got=%d", len(tt.expectedParams), len(function.Parameters))
+		}
+		for i, ident := range tt.expectedParams {
+			testLiteralExpression(t, function.Parameters[i], ident)
+		}
+		if function.Variadic != tt.variadic {
+			t.Errorf("function.Variadic wrong. want %t, got=%t", tt.variadic, function.Variadic)
+		}
+		if got := function.String(); got != tt.expectedString {
+			t.Errorf("function.String() wrong. want %q, got=%q", tt.expectedString, got)
+		}
+	}
+
+	for _, input := range []string{"fn(rest..., x) {}", "fn(a, b...c) {}"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected errors", input)
+		}
+	}
+
+	// every parameter, including the variadic one, has to be a name
+	errorTests := []struct {
+		input string
+		err   string
+	}{
+		{`fn(1, "a", ...) { 1 }`, "1:4: Expected token IDENT -- Got INT"},
+		{"fn g(+) {}", "1:6: Expected token IDENT -- Got +"},
+		{"class A { fn operator +(1) {} }", "1:25: Expected token IDENT -- Got INT"},
+		{"fn(a, ...) {}", "1:7: Expected token IDENT -- Got ..."},
+	}
+	for _, tt := range errorTests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 || p.Errors()[0] != tt.err {
+			t.Errorf("%q: wrong errors. want %q first, got=%q", tt.input, tt.err, p.Errors())
+		}
+	}
+}
+
+func TestFunctionStatement(t *testing.T) {
+	input := `fn add(a, b) { a + b }
+fn log(msg, args...) { print(msg) }
+add(1, 2);
+fn(x) { x }(5);`
+
+	p := New(lexer.New(input))
 	program := p.ParseProgram()
 	checkParserErrors(t, p)
-	if len(program.Statements) != 1 {
-		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
-			1, len(program.Statements))
+
+	if len(program.Statements) != 4 {
+		t.Fatalf("program.Statements does not contain 4 statements. got=%d", len(program.Statements))
 	}
-	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	stmt, ok := program.Statements[0].(*ast.FunctionStatement)
 	if !ok {
-		t.Fatalf("stmt is not ast.ExpressionStatement. got=%T",
-			program.Statements[0])
+		t.Fatalf("stmt is not ast.FunctionStatement. got=%T", program.Statements[0])
 	}
-	exp, ok := stmt.Expression.(*ast.CallExpression)
+	testIdentifier(t, stmt.Name, "add")
+	if len(stmt.Function.Parameters) != 2 {
+		t.Fatalf("function literal parameters wrong. want 2, got=%d", len(stmt.Function.Parameters))
+	}
+	testLiteralExpression(t, stmt.Function.Parameters[0], "a")
+	testLiteralExpression(t, stmt.Function.Parameters[1], "b")
+	if len(stmt.Function.Body.Statements) != 1 {
+		t.Fatalf("function.Body.Statements has not 1 statements. got=%d", len(stmt.Function.Body.Statements))
+	}
+	if !program.Statements[1].(*ast.FunctionStatement).Function.Variadic {
+		t.Errorf("log is not variadic")
+	}
+	if _, ok := program.Statements[3].(*ast.ExpressionStatement); !ok {
+		t.Errorf("anonymous fn is not an ast.ExpressionStatement. got=%T", program.Statements[3])
+	}
+	want := "fn add(a,b)(a + b)fn log(msg,args...)print(msg)add(1,2)fn(x)x(5)"
+	if got := program.String(); got != want {
+		t.Errorf("program.String() wrong. want=%q, got=%q", want, got)
+	}
+
+	for _, input := range []string{"fn add { a }", "fn add(a, b) a + b", "fn add(a, b"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected parser errors", input)
+		}
+	}
+}
+
+func TestYieldStatement(t *testing.T) {
+	input := `let count = fn(n) {
+	let i = 0;
+	while (i < n) { yield i; i += 1; }
+	let helper = fn() { 1 };
+};
+let plain = fn(xs) { fn() { yield xs; } };`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	count := program.Statements[0].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	if !count.Generator {
+		t.Errorf("count is not a generator")
+	}
+	loop := count.Body.Statements[1].(*ast.WhileStatement)
+	yield, ok := loop.Body.Statements[0].(*ast.YieldStatement)
 	if !ok {
-		t.Fatalf("stmt.Expression is not ast.CallExpression. got=%T",
-			stmt.Expression)
+		t.Fatalf("stmt is not ast.YieldStatement. got=%T", loop.Body.Statements[0])
 	}
-	if !testIdentifier(t, exp.Function, "add") {
-		return
+	testIdentifier(t, yield.Value, "i")
+	if got := yield.String(); got != "yield i;" {
+		t.Errorf("yield.String() wrong. got=%q", got)
 	}
-	if len(exp.Arguments) != 3 {
-		t.Fatalf("wrong length of arguments. got=%d", len(exp.Arguments))
+	helper := count.Body.Statements[2].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	if helper.Generator {
+		t.Errorf("helper is a generator")
+	}
+
+	plain := program.Statements[1].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	if plain.Generator {
+		t.Errorf("plain is a generator, only its inner function yields")
+	}
+	inner := plain.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	if !inner.Generator {
+		t.Errorf("inner is not a generator")
+	}
+
+	p = New(lexer.New("yield 1;"))
+	p.ParseProgram()
+	if len(p.Errors()) != 1 || p.Errors()[0] != "1:1: yield outside of a function body" {
+		t.Errorf("wrong errors. got=%q", p.Errors())
+	}
+}
+
+func TestAssertStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		message  string // empty when absent
+		expected string
+	}{
+		{`assert x > 0, "x must be positive";`, "x must be positive", "assert (x > 0), x must be positive;"},
+		{"assert x > 0;", "", "assert (x > 0);"},
+		{"assert x > 0", "", "assert (x > 0);"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.AssertStatement)
+		if !ok {
+			t.Fatalf("stmt is not ast.AssertStatement. got=%T", program.Statements[0])
+		}
+		testInfixExpression(t, stmt.Condition, "x", ">", 0)
+		if tt.message == "" {
+			if stmt.Message != nil {
+				t.Errorf("expected no message, got %s", stmt.Message)
+			}
+		} else if msg, ok := stmt.Message.(*ast.StringLiteral); !ok || msg.Value != tt.message {
+			t.Errorf("message wrong. want=%q, got=%s", tt.message, stmt.Message)
+		}
+		if got := program.String(); got != tt.expected {
+			t.Errorf("program.String() wrong. want=%q, got=%q", tt.expected, got)
+		}
+	}
+
+	for _, input := range []string{"assert;", "assert x, ;"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected parser errors", input)
+		}
+	}
+}
+
+func TestClassStatement(t *testing.T) {
+	input := `class Counter {
+	fn init(start) { let n = start; }
+	fn get() { self.n }
+}
+class Empty {}`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+	class, ok := program.Statements[0].(*ast.ClassStatement)
+	if !ok {
+		t.Fatalf("stmt is not ast.ClassStatement. got=%T", program.Statements[0])
+	}
+	testIdentifier(t, class.Name, "Counter")
+	if len(class.Methods) != 2 {
+		t.Fatalf("wrong number of methods. got=%d", len(class.Methods))
+	}
+	testIdentifier(t, class.Methods[0].Name, "init")
+	testLiteralExpression(t, class.Methods[0].Function.Parameters[0], "start")
+	testIdentifier(t, class.Methods[1].Name, "get")
+
+	want := "class Counter { fn init(start)let n = start; fn get()self.n }class Empty {}"
+	if got := program.String(); got != want {
+		t.Errorf("program.String() wrong. want=%q, got=%q", want, got)
+	}
+
+	for _, input := range []string{"class { }", "class A { let x = 1; }", "class A { fn (x) {} }", "class A { fn f() {}"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected parser errors", input)
+		}
+	}
+}
+
+func TestOperatorMethods(t *testing.T) {
+	input := `class Vec {
+	fn init(x, y) { x }
+	fn operator +(other) { add(self, other) }
+	fn operator ==(other) { eq(self, other) }
+	fn operator(x) { x }
+}`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	class := program.Statements[0].(*ast.ClassStatement)
+	if len(class.Methods) != 2 {
+		t.Fatalf("wrong number of methods. got=%d", len(class.Methods))
+	}
+	testIdentifier(t, class.Methods[1].Name, "operator")
+	if len(class.Operators) != 2 {
+		t.Fatalf("wrong number of operators. got=%d", len(class.Operators))
+	}
+	for i, want := range []string{"+", "=="} {
+		op := class.Operators[i]
+		if op.Operator != want {
+			t.Errorf("operator %d wrong. want=%q, got=%q", i, want, op.Operator)
+		}
+		testLiteralExpression(t, op.Function.Parameters[0], "other")
+	}
+	if got := class.Operators[0].String(); got != "fn operator +(other)add(self,other)" {
+		t.Errorf("operator.String() wrong. got=%q", got)
+	}
+
+	// members print and compare in the order they were written
+	ordered := New(lexer.New("class A { fn operator +(o) { 1 } fn m() { 2 } }")).ParseProgram()
+	if want := "class A { fn operator +(o)1 fn m()2 }"; ordered.String() != want {
+		t.Errorf("class.String() wrong. want=%q, got=%q", want, ordered.String())
+	}
+	if got := ast.Format(ordered); got != "class A {\n\tfn operator +(o) {\n\t\t1;\n\t}\n\n\tfn m() {\n\t\t2;\n\t}\n}\n" {
+		t.Errorf("ast.Format wrong. got=%q", got)
+	}
+	// the order of methods relative to operators comes from token positions,
+	// which ast.Equal ignores
+	literal := func(v int64) *ast.BlockStatement {
+		return &ast.BlockStatement{Statements: []ast.Statement{
+			&ast.ExpressionStatement{Expression: &ast.IntegerLiteral{Value: v}},
+		}}
+	}
+	built := &ast.Program{Statements: []ast.Statement{&ast.ClassStatement{
+		Name: &ast.Identifier{Value: "A"},
+		Methods: []*ast.FunctionStatement{{
+			Name:     &ast.Identifier{Value: "m"},
+			Function: &ast.FunctionLiteral{Parameters: []*ast.Identifier{}, Body: literal(2)},
+		}},
+		Operators: []*ast.OperatorMethod{{
+			Operator: "+",
+			Function: &ast.FunctionLiteral{Parameters: []*ast.Identifier{{Value: "o"}}, Body: literal(1)},
+		}},
+	}}}
+	if !ast.Equal(ordered, built) {
+		t.Errorf("ast.Equal(%s, %s) = false, want true", ordered, built)
+	}
+
+	p = New(lexer.New("class A { fn operator &&(b) { b } }"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 || p.Errors()[0] != "1:23: cannot define operator &&" {
+		t.Errorf("wrong errors. got=%q", p.Errors())
+	}
+}
+
+func TestQuoteUnquote(t *testing.T) {
+	p := New(lexer.New("quote(1 + unquote(a * b));"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	quote, ok := stmt.Expression.(*ast.QuoteExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.QuoteExpression. got=%T", stmt.Expression)
+	}
+	infix, ok := quote.Node.(*ast.InfixExpression)
+	if !ok {
+		t.Fatalf("quote.Node not *ast.InfixExpression. got=%T", quote.Node)
+	}
+	unquote, ok := infix.Right.(*ast.UnquoteExpression)
+	if !ok {
+		t.Fatalf("infix.Right not *ast.UnquoteExpression. got=%T", infix.Right)
+	}
+	testInfixExpression(t, unquote.Node, "a", "*", "b")
+	if got := program.String(); got != "quote((1 + unquote((a * b))))" {
+		t.Errorf("program.String() wrong. got=%q", got)
+	}
+
+	for _, input := range []string{"quote;", "quote(1, 2);", "unquote();", "quote(1"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected parser errors", input)
+		}
+	}
+}
+
+func TestMacroLiteral(t *testing.T) {
+	p := New(lexer.New("macro(x, y) { x + y; };"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	macro, ok := stmt.Expression.(*ast.MacroLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.MacroLiteral. got=%T", stmt.Expression)
+	}
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("macro literal parameters wrong. want 2, got=%d", len(macro.Parameters))
+	}
+	testLiteralExpression(t, macro.Parameters[0], "x")
+	testLiteralExpression(t, macro.Parameters[1], "y")
+	if len(macro.Body.Statements) != 1 {
+		t.Fatalf("macro.Body.Statements has not 1 statements. got=%d", len(macro.Body.Statements))
+	}
+	body := macro.Body.Statements[0].(*ast.ExpressionStatement)
+	testInfixExpression(t, body.Expression, "x", "+", "y")
+
+	p = New(lexer.New("macro(a, rest...) { a };"))
+	p.ParseProgram()
+	if want := "1:10: variadic macro parameters are not supported"; len(p.Errors()) != 1 || p.Errors()[0] != want {
+		t.Errorf("wrong errors. want %q, got=%q", want, p.Errors())
+	}
+}
+
+func TestMemberExpression(t *testing.T) {
+	p := New(lexer.New("config.server.port;"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	outer, ok := stmt.Expression.(*ast.MemberExpression)
+	if !ok {
+		t.Fatalf("exp is not ast.MemberExpression. got=%T", stmt.Expression)
+	}
+	testIdentifier(t, outer.Property, "port")
+	inner, ok := outer.Object.(*ast.MemberExpression)
+	if !ok {
+		t.Fatalf("outer.Object is not ast.MemberExpression. got=%T", outer.Object)
+	}
+	testIdentifier(t, inner.Object, "config")
+	testIdentifier(t, inner.Property, "server")
+
+	for _, input := range []string{"a.;", `a."b";`, "a.(b);"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected errors", input)
+		}
+	}
+}
+
+func TestMethodCallExpression(t *testing.T) {
+	p := New(lexer.New("arr.map(fn(x){x*2}).len();"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	outer, ok := stmt.Expression.(*ast.CallExpression)
+	if !ok {
+		t.Fatalf("exp is not ast.CallExpression. got=%T", stmt.Expression)
+	}
+	if len(outer.Arguments) != 0 {
+		t.Fatalf("wrong length of arguments. got=%d", len(outer.Arguments))
+	}
+	lenMember, ok := outer.Function.(*ast.MemberExpression)
+	if !ok {
+		t.Fatalf("outer.Function is not ast.MemberExpression. got=%T", outer.Function)
+	}
+	testIdentifier(t, lenMember.Property, "len")
+
+	inner, ok := lenMember.Object.(*ast.CallExpression)
+	if !ok {
+		t.Fatalf("len.Object is not ast.CallExpression. got=%T", lenMember.Object)
+	}
+	method, ok := inner.Function.(*ast.MemberExpression)
+	if !ok {
+		t.Fatalf("inner.Function is not ast.MemberExpression. got=%T", inner.Function)
+	}
+	testIdentifier(t, method.Object, "arr")
+	testIdentifier(t, method.Property, "map")
+	if len(inner.Arguments) != 1 {
+		t.Fatalf("wrong length of arguments. got=%d", len(inner.Arguments))
+	}
+	if _, ok := inner.Arguments[0].(*ast.FunctionLiteral); !ok {
+		t.Fatalf("argument is not ast.FunctionLiteral. got=%T", inner.Arguments[0])
+	}
+}
+
+func TestIndexAndSliceExpressions(t *testing.T) {
+	p := New(lexer.New("xs[i + 1];"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	index, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.IndexExpression. got=%T", program.Statements[0].(*ast.ExpressionStatement).Expression)
+	}
+	testIdentifier(t, index.Left, "xs")
+	testInfixExpression(t, index.Index, "i", "+", 1)
+
+	tests := []struct {
+		input string
+		low   interface{} // nil when left out
+		high  interface{}
+	}{
+		{"arr[1:3];", 1, 3},
+		{"arr[:2];", nil, 2},
+		{"arr[2:];", 2, nil},
+		{"arr[:];", nil, nil},
+		{"arr[lo:hi];", "lo", "hi"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		slice, ok := stmt.Expression.(*ast.SliceExpression)
+		if !ok {
+			t.Fatalf("%q: exp not *ast.SliceExpression. got=%T", tt.input, stmt.Expression)
+		}
+		testIdentifier(t, slice.Left, "arr")
+		for _, bound := range []struct {
+			exp  ast.Expression
+			want interface{}
+		}{{slice.Low, tt.low}, {slice.High, tt.high}} {
+			if bound.want == nil {
+				if bound.exp != nil {
+					t.Errorf("%q: expected no bound, got %s", tt.input, bound.exp)
+				}
+			} else {
+				testLiteralExpression(t, bound.exp, bound.want)
+			}
+		}
+	}
+
+	for _, input := range []string{"xs[];", "xs[1;", "xs[1:2;", "xs[1:2:3];"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected parser errors", input)
+		}
+	}
+}
+
+func TestNegativeIndex(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"arr[-1];", "(arr[(-1)])"},
+		{"arr[-i];", "(arr[(-i)])"},
+		{"arr[-1][-2];", "((arr[(-1)])[(-2)])"},
+		{"arr[-(1 + 2)];", "(arr[(-(1 + 2))])"},
+		{"arr[- 1 - 1];", "(arr[((-1) - 1)])"},
+		{"-arr[-1];", "(-(arr[(-1)]))"},
+		{"arr[-2:-1];", "(arr[(-2):(-1)])"},
+		{"arr[:-1];", "(arr[:(-1)])"},
+		{"arr[\n-1];", "(arr[(-1)])"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	p := New(lexer.New("arr[-1];"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	index, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.IndexExpression. got=%T", program.Statements[0].(*ast.ExpressionStatement).Expression)
+	}
+	testIdentifier(t, index.Left, "arr")
+	prefix, ok := index.Index.(*ast.PrefixExpression)
+	if !ok {
+		t.Fatalf("index.Index not *ast.PrefixExpression. got=%T", index.Index)
+	}
+	if prefix.Operator != "-" {
+		t.Errorf("prefix.Operator is not %q. got=%q", "-", prefix.Operator)
+	}
+	testIntegerLiteral(t, prefix.Right, 1)
+}
+
+func TestCallExpressionParsing(t *testing.T) {
+	input := "add(1, 2 * 3, 4 + 5);"
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("stmt is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+	exp, ok := stmt.Expression.(*ast.CallExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.CallExpression. got=%T",
+			stmt.Expression)
+	}
+	if !testIdentifier(t, exp.Function, "add") {
+		return
+	}
+	if len(exp.Arguments) != 3 {
+		t.Fatalf("wrong length of arguments. got=%d", len(exp.Arguments))
+	}
+	testLiteralExpression(t, exp.Arguments[0], 1)
+	testInfixExpression(t, exp.Arguments[1], 2, "*", 3)
+	testInfixExpression(t, exp.Arguments[2], 4, "+", 5)
+}
+
+func TestNamedArguments(t *testing.T) {
+	p := New(lexer.New("draw(shape, x: 1, y: a + b, fill: ok ? red : blue);"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	exp := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	if len(exp.Arguments) != 4 {
+		t.Fatalf("wrong length of arguments. got=%d", len(exp.Arguments))
+	}
+	testIdentifier(t, exp.Arguments[0], "shape")
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"x", "1"},
+		{"y", "(a + b)"},
+		{"fill", "(ok ? red : blue)"},
+	}
+	for i, tt := range tests {
+		arg, ok := exp.Arguments[i+1].(*ast.NamedArgument)
+		if !ok {
+			t.Fatalf("argument %d is not ast.NamedArgument. got=%T", i+1, exp.Arguments[i+1])
+		}
+		testIdentifier(t, arg.Name, tt.name)
+		if arg.Value.String() != tt.value {
+			t.Errorf("argument %d value wrong. want=%q, got=%q", i+1, tt.value, arg.Value.String())
+		}
+	}
+	if got := exp.String(); got != "draw(shape,x:1,y:(a + b),fill:(ok ? red : blue))" {
+		t.Errorf("exp.String() wrong. got=%q", got)
+	}
+
+	p = New(lexer.New("draw(x: );"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected an error for a named argument without a value")
+	}
+}
+
+func TestConcurrentParsers(t *testing.T) {
+	input := "let x = 5; if (x < y) { x } else { -y * 2 }; fn(a, b) { a + b };"
+	expected := New(lexer.New(input)).ParseProgram().String()
+
+	var wg sync.WaitGroup
+	results := make([]string, 16)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p := New(lexer.New(input))
+			results[i] = p.ParseProgram().String()
+		}(i)
+	}
+	wg.Wait()
+
+	for i, got := range results {
+		if got != expected {
+			t.Errorf("parser %d: expected=%q, got=%q", i, expected, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	p := New(lexer.New("let = 5;"))
+	p.ParseProgram()
+
+	errs := p.ParseErrors()
+	if len(errs) == 0 {
+		t.Fatalf("expected parse errors, got none")
+	}
+	err := errs[0]
+	if err.Expected != token.IDENT || err.Token.Type != token.ASSIGN {
+		t.Errorf("wrong error data. Expected IDENT/=, got %s/%s", err.Expected, err.Token.Type)
+	}
+	if msg := p.Errors()[0]; msg != "1:5: Expected token IDENT -- Got =" {
+		t.Errorf("wrong error message. got=%q", msg)
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	p := New(lexer.New("let x = 1;\nlet y = (2;\n  let = 3;"))
+	p.ParseProgram()
+
+	errs := p.ParseErrors()
+	if len(errs) == 0 {
+		t.Fatalf("expected parse errors, got none")
+	}
+	if pos := errs[0].Pos; pos.Line != 2 || pos.Column != 11 || pos.Offset != 21 {
+		t.Errorf("wrong position. want 2:11 at offset 21, got %s at offset %d", pos, pos.Offset)
+	}
+	if got := p.Errors()[1]; got != "3:7: Expected token IDENT -- Got =" {
+		t.Errorf("wrong error message. got=%q", got)
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(a, b) { a + b };
+add(1, -x) * 2;
+if (x) { y } else if (z) { w }
+xs[1:] |> map(f);
+let p = Point{x: 1};
+let h = {"a": (1, 2)};
+do { i += 1 } while (i < 3);
+"s ${x} t";
+return a, b;`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	expected := []string{
+		"let add = fn(a, b) { a + b }",
+		"add(1, -x) * 2",
+		"if (x) { y } else if (z) { w }",
+		"xs[1:] |> map(f)",
+		"let p = Point{x: 1}",
+		`let h = {"a": (1, 2)}`,
+		"do { i += 1 } while (i < 3)",
+		`"s ${x} t"`,
+		"return a, b",
+	}
+	if len(program.Statements) != len(expected) {
+		t.Fatalf("wrong number of statements. want=%d, got=%d", len(expected), len(program.Statements))
+	}
+	for i, want := range expected {
+		stmt := program.Statements[i]
+		if got := input[stmt.Pos():stmt.End()]; got != want {
+			t.Errorf("statement %d: source wrong. want=%q, got=%q", i, want, got)
+		}
+	}
+	// statements end before their semicolon
+	if program.Pos() != 0 || program.End() != len(input)-1 {
+		t.Errorf("program span wrong. want [0:%d], got [%d:%d]", len(input)-1, program.Pos(), program.End())
+	}
+
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.InfixExpression).Left.(*ast.CallExpression)
+	if got := input[call.Arguments[1].Pos():call.Arguments[1].End()]; got != "-x" {
+		t.Errorf("argument source wrong. got=%q", got)
+	}
+	fn := program.Statements[0].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	if got := input[fn.Body.Pos():fn.Body.End()]; got != "{ a + b }" {
+		t.Errorf("body source wrong. got=%q", got)
+	}
+	if tok := call.Token; tok.Line != 2 || tok.Column != 4 {
+		t.Errorf("call token position wrong. want 2:4, got %d:%d", tok.Line, tok.Column)
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	src := "let x = 1;\n\tlet y = (2 + ;\nlet s = \"\\q\";"
+	p := New(lexer.New(src))
+	p.ParseProgram()
+
+	expected := `error[E002]: no prefix parse function for ; found
+ --> 2:15
+  |
+2 | 	let y = (2 + ;
+  | 	             ^
+
+error[E007]: invalid escape sequence "\\q" in string
+ --> 3:10
+  |
+3 | let s = "\q";
+  |          ^
+`
+	if got := FormatErrors(src, p.ParseErrors()); got != expected {
+		t.Errorf("wrong output.\nwant:\n%s\ngot:\n%s", expected, got)
+	}
+
+	src = "let = 5;"
+	p = New(lexer.New(src))
+	p.ParseProgram()
+	expected = `error[E001]: Expected token IDENT -- Got =
+ --> 1:5
+  |
+1 | let = 5;
+  |     ^
+`
+	if got := FormatErrors(src, p.ParseErrors()[:1]); got != expected {
+		t.Errorf("wrong output.\nwant:\n%s\ngot:\n%s", expected, got)
+	}
+
+	src = "a;" + strings.Repeat("\n", 11) + "let 12345 = 1"
+	p = New(lexer.New(src))
+	p.ParseProgram()
+	expected = `error[E001]: Expected token IDENT -- Got INT
+  --> 12:5
+   |
+12 | let 12345 = 1
+   |     ^^^^^
+`
+	if got := FormatErrors(src, p.ParseErrors()[:1]); got != expected {
+		t.Errorf("wrong output.\nwant:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+// benchFile is a script of the benchmark corpus, testdata/*.monkey at the
+// module root, which the lexer benchmarks share.
+type benchFile struct {
+	name, src string
+}
+
+func benchCorpus(b *testing.B) []benchFile {
+	paths, err := filepath.Glob("../testdata/*.monkey")
+	if err != nil || len(paths) == 0 {
+		b.Fatalf("no benchmark corpus: %v", err)
+	}
+	files := make([]benchFile, len(paths))
+	for i, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		files[i] = benchFile{filepath.Base(path), string(src)}
+	}
+	return files
+}
+
+// largeScript repeats src to roughly 10k lines.
+func largeScript(src string) string {
+	return strings.Repeat(src, max(1, 10000/(strings.Count(src, "\n")+1)))
+}
+
+// benchDeepScript nests grouped expressions and operators 500 levels deep.
+var benchDeepScript = strings.Repeat("(1 + ", 500) + "1" + strings.Repeat(")", 500) +
+	";" + strings.Repeat("-", 500) + "x;"
+
+func benchmarkParser(b *testing.B, input string) {
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			b.Fatalf("parse errors: %q", p.Errors())
+		}
+	}
+}
+
+// BenchmarkParser parses each corpus file, once as written and once repeated
+// to some ten thousand lines.
+func BenchmarkParser(b *testing.B) {
+	for _, file := range benchCorpus(b) {
+		b.Run(file.name, func(b *testing.B) { benchmarkParser(b, file.src) })
+		b.Run(file.name+"/large", func(b *testing.B) { benchmarkParser(b, largeScript(file.src)) })
+	}
+}
+
+func BenchmarkParserDeep(b *testing.B) { benchmarkParser(b, benchDeepScript) }
+
+func BenchmarkProgramString(b *testing.B) {
+	for _, file := range benchCorpus(b) {
+		program := New(lexer.New(largeScript(file.src))).ParseProgram()
+		b.Run(file.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_ = program.String()
+			}
+		})
+	}
+}
+
+func TestMaxNestingDepth(t *testing.T) {
+	tests := []string{
+		strings.Repeat("(", 10000) + "1" + strings.Repeat(")", 10000),
+		strings.Repeat("-", 100000) + "x",
+		"x;" + strings.Repeat("if (x) { ", 5000),
+		strings.Repeat("while (x) { ", 100000),
+		strings.Repeat("fn f() { ", 100000),
+		strings.Repeat("do { ", 100000),
+		strings.Repeat("for (;;) { ", 100000),
+	}
+
+	for i, input := range tests {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != 1 {
+			t.Fatalf("tests[%d] - expected 1 error, got %d", i, len(errors))
+		}
+		if _, msg, _ := strings.Cut(errors[0], ": "); !strings.HasPrefix(msg, "maximum nesting depth of 4096 exceeded") {
+			t.Errorf("tests[%d] - wrong error. got=%q", i, errors[0])
+		}
+	}
+
+	p := New(lexer.New(benchDeepScript))
+	p.ParseProgram()
+	checkParserErrors(t, p)
+}
+
+func TestErrorLimit(t *testing.T) {
+	input := strings.Repeat("let = 1;\n", 100)
+	tests := []struct {
+		opts     []Option
+		expected int
+	}{
+		{nil, 20},
+		{[]Option{WithErrorLimit(5)}, 5},
+		{[]Option{WithErrorLimit(0)}, 200},
+		{[]Option{WithFailFast()}, 1},
+	}
+
+	for i, tt := range tests {
+		p := New(lexer.New(input), tt.opts...)
+		p.ParseProgram()
+		if got := len(p.Errors()); got != tt.expected {
+			t.Errorf("tests[%d] - wrong number of errors. want=%d, got=%d", i, tt.expected, got)
+		}
+	}
+
+	p := New(lexer.New("let x = 1; let = 2; let y = 3;"), WithFailFast())
+	program := p.ParseProgram()
+	if len(p.Errors()) != 1 || p.Errors()[0] != "1:16: Expected token IDENT -- Got =" {
+		t.Errorf("wrong errors. got=%q", p.Errors())
+	}
+	if len(program.Statements) != 1 {
+		t.Errorf("parse did not stop at the first error. got %d statements", len(program.Statements))
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"if (x) { }", []string{"1:8: empty if body"}},
+		{"if (x) { y } else {}", []string{"1:19: empty else body"}},
+		{"while (x) {}", []string{"1:11: empty while body"}},
+		{"do {} while (x);", []string{"1:4: empty do body"}},
+		{"for (let i = 0; i < 3; i += 1) {}\nfor (x in xs) {}", []string{"1:32: empty for body", "2:15: empty for body"}},
+		{"while (n -= 1) { f(n) }", []string{"1:10: assignment -= used as a condition"}},
+		{"if (x += 1) {}", []string{"1:7: assignment += used as a condition", "1:13: empty if body"}},
+		{"let f = fn() {}; if (x) { y } else { z }", nil},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		checkParserErrors(t, p)
+
+		warnings := p.Warnings()
+		if len(warnings) != len(tt.expected) {
+			t.Errorf("%q: wrong warnings. want=%q, got=%v", tt.input, tt.expected, warnings)
+			continue
+		}
+		for i, want := range tt.expected {
+			if got := warnings[i].String(); got != want {
+				t.Errorf("%q: warning %d wrong. want=%q, got=%q", tt.input, i, want, got)
+			}
+		}
+	}
+}
+
+func TestTracer(t *testing.T) {
+	var events []string
+	depth := 0
+	tracer := func(e TraceEvent) {
+		if e.Enter {
+			depth++
+		} else {
+			depth--
+		}
+		events = append(events, e.String())
+	}
+
+	p := New(lexer.New("-5;"), WithTracer(tracer))
+	p.ParseProgram()
+	checkParserErrors(t, p)
+
+	expected := []string{
+		`BEGIN parseExpressionStatement (- "-")`,
+		`BEGIN parseExpression (- "-")`,
+		`BEGIN parsePrefixExpression (- "-")`,
+		`BEGIN parseExpression (INT "5")`,
+		`BEGIN parseIntegerLiteral (INT "5")`,
+		`END parseIntegerLiteral (INT "5")`,
+		`END parseExpression (INT "5")`,
+		`END parsePrefixExpression (INT "5")`,
+		`END parseExpression (INT "5")`,
+		`END parseExpressionStatement (; ";")`,
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("wrong number of events. want %d, got %d: %q", len(expected), len(events), events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("events[%d] wrong. want %q, got %q", i, expected[i], events[i])
+		}
+	}
+	if depth != 0 {
+		t.Errorf("unbalanced trace events, depth %d", depth)
+	}
+}
+
+func TestLetStatementValues(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedID    string
+		expectedValue string
+	}{
+		{"let x = 5;", "x", "5"},
+		{"let y = true;", "y", "true"},
+		{"let foobar = y;", "foobar", "y"},
+		{"let x = 5 * 5;", "x", "(5 * 5)"},
+		{"let z = -a + b * (c - 1);", "z", "((-a) + (b * (c - 1)))"},
+		{"let f = fn(x, y) { x + y };", "f", "fn(x,y)(x + y)"},
+		{"let m = if (a < b) { a } else { b }", "m", "if(a < b) aelseb"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+				len(program.Statements))
+		}
+		stmt := program.Statements[0]
+		if !testLetStatement(t, stmt, tt.expectedID) {
+			return
+		}
+		val := stmt.(*ast.LetStatement).Value
+		if val == nil {
+			t.Fatalf("%q: let statement has no value", tt.input)
+		}
+		if val.String() != tt.expectedValue {
+			t.Errorf("%q: value wrong. expected=%q, got=%q", tt.input, tt.expectedValue, val.String())
+		}
+	}
+}
+
+func TestReturnStatementValues(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedValue string
+	}{
+		{"return 5;", "5"},
+		{"return x", "x"},
+		{"return a + b * c;", "(a + (b * c))"},
+		{"return fn(x) { x * 2 };", "fn(x)(x * 2)"},
+		{"return;", ""},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+				len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ReturnStatement)
+		if !ok {
+			t.Fatalf("stmt not *ast.ReturnStatement. got=%T", program.Statements[0])
+		}
+		got := ""
+		if stmt.ReturnValue != nil {
+			got = stmt.ReturnValue.String()
+		}
+		if got != tt.expectedValue {
+			t.Errorf("%q: return value wrong. expected=%q, got=%q", tt.input, tt.expectedValue, got)
+		}
+	}
+}
+
+func TestReturnInsideBlock(t *testing.T) {
+	input := "fn(x) { if (x) { return; } return x * 2 }"
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if got := program.String(); got != "fn(x)ifx return ;return (x * 2);" {
+		t.Errorf("program wrong. got=%q", got)
+	}
+}
+
+func TestCallExpressionArguments(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedFunc string
+		expectedArgs []string
+	}{
+		{"add();", "add", []string{}},
+		{"add(1);", "add", []string{"1"}},
+		{"add(1, 2 * 3, fn(x){x});", "add", []string{"1", "(2 * 3)", "fn(x)x"}},
+		{"apply(f)(x, g(y));", "apply(f)", []string{"x", "g(y)"}},
+		{"fn(x){x}(5);", "fn(x)x", []string{"5"}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.CallExpression)
+		if !ok {
+			t.Fatalf("stmt.Expression is not ast.CallExpression. got=%T", stmt.Expression)
+		}
+		if exp.Function.String() != tt.expectedFunc {
+			t.Errorf("%q: function wrong. expected=%q, got=%q", tt.input, tt.expectedFunc, exp.Function.String())
+		}
+		if len(exp.Arguments) != len(tt.expectedArgs) {
+			t.Fatalf("%q: wrong length of arguments. want %d, got=%d", tt.input, len(tt.expectedArgs), len(exp.Arguments))
+		}
+		for i, arg := range tt.expectedArgs {
+			if exp.Arguments[i].String() != arg {
+				t.Errorf("%q: argument %d wrong. expected=%q, got=%q", tt.input, i, arg, exp.Arguments[i].String())
+			}
+		}
+	}
+}
+
+func TestNullLiteral(t *testing.T) {
+	p := New(lexer.New("let x = null; f(null, 1); if (x == null) { return null; }"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	let := program.Statements[0].(*ast.LetStatement)
+	if _, ok := let.Value.(*ast.NullLiteral); !ok {
+		t.Fatalf("let.Value not *ast.NullLiteral. got=%T", let.Value)
+	}
+	if got := program.String(); got != "let x = null;f(null,1)if(x == null) return null;" {
+		t.Errorf("program wrong. got=%q", got)
+	}
+}
+
+func TestStringLiteralExpression(t *testing.T) {
+	input := `"hello world";`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.StringLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.StringLiteral. got=%T", stmt.Expression)
+	}
+	if literal.Value != "hello world" {
+		t.Errorf("literal.Value not %q. got=%q", "hello world", literal.Value)
+	}
+
+	p = New(lexer.New(`let s = "abc`))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 || p.Errors()[0] != "1:9: unterminated string" {
+		t.Errorf("wrong errors. got=%q", p.Errors())
+	}
+}
+
+func TestRawStringLiteral(t *testing.T) {
+	input := "`raw \\n ${x}\nsecond line`;"
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.StringLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.StringLiteral. got=%T", stmt.Expression)
+	}
+	if literal.Token.Type != token.RAW_STRING {
+		t.Errorf("literal.Token.Type not %q. got=%q", token.RAW_STRING, literal.Token.Type)
+	}
+	if want := "raw \\n ${x}\nsecond line"; literal.Value != want {
+		t.Errorf("literal.Value not %q. got=%q", want, literal.Value)
+	}
+}
+
+func TestRegexLiteral(t *testing.T) {
+	tests := []struct {
+		input   string
+		pattern string
+		flags   string
+	}{
+		{`/ab+c/;`, "ab+c", ""},
+		{`/a\/b[/]c/gi;`, `a\/b[/]c`, "gi"},
+		{`/=+/;`, "=+", ""},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		regex, ok := stmt.Expression.(*ast.RegexLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.RegexLiteral. got=%T", stmt.Expression)
+		}
+		if regex.Pattern != tt.pattern || regex.Flags != tt.flags {
+			t.Errorf("%q: wrong regex. want pattern=%q flags=%q, got pattern=%q flags=%q",
+				tt.input, tt.pattern, tt.flags, regex.Pattern, regex.Flags)
+		}
+	}
+
+	// a slash in operator position is still division
+	p := New(lexer.New("let r = find(s, /\\d+/i) / 2 / n; x /= /y/;"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if got := program.String(); got != `let r = ((find(s,/\d+/i) / 2) / n);(x /= /y/)` {
+		t.Errorf("program wrong. got=%q", got)
+	}
+
+	// what the lexer did with the text after the slash before the rescan,
+	// opening an interpolation or reporting an error, is undone
+	p = New(lexer.New(`let s = "${ /{/ }"; let t = "${ /}/ }"; let r = /"\q"/; 1`))
+	program = p.ParseProgram()
+	checkParserErrors(t, p)
+	if got := program.String(); got != `let s = ${/{/};let t = ${/}/};let r = /"\q"/;1` {
+		t.Errorf("program wrong. got=%q", got)
+	}
+
+	p = New(lexer.New("let r = /abc\n;"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 || p.Errors()[0] != "1:9: unterminated regular expression" {
+		t.Errorf("wrong errors. got=%q", p.Errors())
+	}
+}
+
+func TestHeredoc(t *testing.T) {
+	input := `let query = <<~SQL
+    select *
+    from users
+    SQL;
+let mask = x << 2;`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+	literal, ok := program.Statements[0].(*ast.LetStatement).Value.(*ast.StringLiteral)
+	if !ok {
+		t.Fatalf("value not *ast.StringLiteral. got=%T", program.Statements[0].(*ast.LetStatement).Value)
+	}
+	if want := "select *\nfrom users"; literal.Value != want {
+		t.Errorf("literal.Value not %q. got=%q", want, literal.Value)
+	}
+	if got := program.Statements[1].String(); got != "let mask = (x << 2);" {
+		t.Errorf("shift wrong. got=%q", got)
+	}
+
+	// the "}" after a bad heredoc was first scanned as closing the
+	// interpolation; the rescan undoes that, so the string still ends there
+	p = New(lexer.New(`let s = "${ <<}"; let t = 1;`))
+	program = p.ParseProgram()
+	if len(p.Errors()) == 0 || p.Errors()[0] != "1:13: expected a heredoc terminator after <<" {
+		t.Errorf("wrong errors. got=%q", p.Errors())
+	}
+	if n := len(program.Statements); n == 0 || program.Statements[n-1].String() != "let t = 1;" {
+		t.Errorf("wrong statements. got=%q", program.String())
+	}
+
+	p = New(lexer.New("let s = <<EOF\nnever closed"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 || p.Errors()[0] != "1:9: unterminated heredoc, expected EOF" {
+		t.Errorf("wrong errors. got=%q", p.Errors())
+	}
+}
+
+func TestInterpolatedString(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string // parts in source order
+	}{
+		{`"hello ${name}, you are ${age + 1}";`, []string{"hello ", "name", ", you are ", "(age + 1)"}},
+		{`"${a}${b}";`, []string{"a", "b"}},
+		{`"sum: ${add(1, {"k": 2})}!";`, []string{"sum: ", "add(1,{k:2})", "!"}},
+		{`"outer ${"inner ${x}"}";`, []string{"outer ", "inner ${x}"}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		str, ok := stmt.Expression.(*ast.InterpolatedString)
+		if !ok {
+			t.Fatalf("exp not *ast.InterpolatedString. got=%T", stmt.Expression)
+		}
+		if len(str.Parts) != len(tt.expected) {
+			t.Fatalf("%q: wrong number of parts. want=%d, got=%d", tt.input, len(tt.expected), len(str.Parts))
+		}
+		for i, part := range str.Parts {
+			if part.String() != tt.expected[i] {
+				t.Errorf("%q: part %d wrong. want=%q, got=%q", tt.input, i, tt.expected[i], part.String())
+			}
+		}
+	}
+
+	for _, input := range []string{`"a ${x";`, `"a ${}";`, `"a ${x y}";`} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected errors", input)
+		}
+	}
+}
+
+func TestHashLiteralParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string // key:value pairs in source order
+	}{
+		{"{}", []string{}},
+		{`{"one": 1, "two": 2, "three": 3}`, []string{"one:1", "two:2", "three:3"}},
+		{`{"a": 1, 2: "b", true: fn(){}}`, []string{"a:1", "2:b", "true:fn()"}},
+		{`{"one": 0 + 1, "two": 10 - 8, x: y * 2,}`, []string{"one:(0 + 1)", "two:(10 - 8)", "x:(y * 2)"}},
+		{`{"outer": {"inner": 1}}`, []string{"outer:{inner:1}"}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		hash, ok := stmt.Expression.(*ast.HashLiteral)
+		if !ok {
+			t.Fatalf("exp is not ast.HashLiteral. got=%T", stmt.Expression)
+		}
+		if len(hash.Pairs) != len(tt.expected) {
+			t.Fatalf("%q: hash.Pairs has wrong length. want %d, got=%d", tt.input, len(tt.expected), len(hash.Pairs))
+		}
+		for i, pair := range hash.Pairs {
+			got := pair.Key.String() + ":" + pair.Value.String()
+			if got != tt.expected[i] {
+				t.Errorf("%q: pair %d wrong. expected=%q, got=%q", tt.input, i, tt.expected[i], got)
+			}
+		}
+	}
+}
+
+func TestStructStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		name     string
+		fields   []string
+		expected string
+	}{
+		{"struct Point { x, y }", "Point", []string{"x", "y"}, "struct Point { x, y }"},
+		{"struct Empty {};", "Empty", []string{}, "struct Empty {}"},
+		{"struct User { name, age, }", "User", []string{"name", "age"}, "struct User { name, age }"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.StructStatement)
+		if !ok {
+			t.Fatalf("stmt is not ast.StructStatement. got=%T", program.Statements[0])
+		}
+		testIdentifier(t, stmt.Name, tt.name)
+		if len(stmt.Fields) != len(tt.fields) {
+			t.Fatalf("wrong number of fields. want=%d, got=%d", len(tt.fields), len(stmt.Fields))
+		}
+		for i, field := range tt.fields {
+			testIdentifier(t, stmt.Fields[i], field)
+		}
+		if got := program.String(); got != tt.expected {
+			t.Errorf("program.String() wrong. want=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestStructLiteral(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"Point{x: 1, y: 2 * z};", "Point{x:1, y:(2 * z)}"},
+		{"let p = Point{};", "let p = Point{};"},
+		{"if (p == Point{x: 0, y: 0,}) { p }", "if(p == Point{x:0, y:0}) p"},
+		{"f(Point{x: a + 1, y: b}).x", "f(Point{x:(a + 1), y:b}).x"},
+		// a { on the next line starts a statement, except inside ( )
+		{"let a = b\n{\"k\": 1}", "let a = b;{k:1}"},
+		{"f(Point\n{x: 1})", "f(Point{x:1})"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.expected {
+			t.Errorf("program.String() wrong. want=%q, got=%q", tt.expected, got)
+		}
+	}
+
+	p := New(lexer.New("Point{x: 1, y: 2};"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	lit, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.StructLiteral)
+	if !ok {
+		t.Fatalf("exp is not ast.StructLiteral. got=%T", program.Statements[0].(*ast.ExpressionStatement).Expression)
+	}
+	testIdentifier(t, lit.Type, "Point")
+	if len(lit.Fields) != 2 {
+		t.Fatalf("wrong number of fields. got=%d", len(lit.Fields))
+	}
+	testIdentifier(t, lit.Fields[0].Name, "x")
+	testIntegerLiteral(t, lit.Fields[0].Value, 1)
+	testIdentifier(t, lit.Fields[1].Name, "y")
+	testIntegerLiteral(t, lit.Fields[1].Value, 2)
+
+	for _, input := range []string{"Point{1: 2};", "Point{x 1};", "Point{x: 1 y: 2};", "struct { x }", "struct P { x: 1 }"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected errors", input)
+		}
+	}
+}
+
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < 10) { let x = x + 1; x }`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.WhileStatement. got=%T",
+			program.Statements[0])
+	}
+	if !testInfixExpression(t, stmt.Condition, "x", "<", 10) {
+		return
+	}
+	if len(stmt.Body.Statements) != 2 {
+		t.Fatalf("body is not 2 statements. got=%d", len(stmt.Body.Statements))
+	}
+	if got := stmt.String(); got != "while(x < 10) let x = (x + 1);x" {
+		t.Errorf("stmt.String() wrong. got=%q", got)
+	}
+}
+
+func TestWhileStatementErrors(t *testing.T) {
+	tests := []string{
+		"while x < 10 { x }",
+		"while (x < 10 { x }",
+		"while (x < 10) x",
+	}
+
+	for _, input := range tests {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected parser errors", input)
+		}
+	}
+}
+
+func TestDoWhileStatement(t *testing.T) {
+	p := New(lexer.New("do { x += 1; } while (x < 10); x"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.DoWhileStatement)
+	if !ok {
+		t.Fatalf("stmt is not ast.DoWhileStatement. got=%T", program.Statements[0])
+	}
+	if len(stmt.Body.Statements) != 1 {
+		t.Fatalf("body is not 1 statement. got=%d", len(stmt.Body.Statements))
+	}
+	if !testInfixExpression(t, stmt.Condition, "x", "<", 10) {
+		return
+	}
+	if got := stmt.String(); got != "do (x += 1) while(x < 10);" {
+		t.Errorf("stmt.String() wrong. got=%q", got)
+	}
+
+	for _, input := range []string{"do x while (y);", "do { x }", "do { x } while y;", "do { x } while (y"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected parser errors", input)
+		}
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	tests := []struct {
+		input     string
+		init      string
+		condition string
+		post      string
+		expected  string
+	}{
+		{
+			"for (let i = 0; i < 10; let i = i + 1) { puts(i); }",
+			"let i = 0;", "(i < 10)", "let i = (i + 1);",
+			"for (let i = 0; (i < 10); let i = (i + 1)) puts(i)",
+		},
+		{
+			"for (i; i > 0; next(i)) { i }",
+			"i", "(i > 0)", "next(i)",
+			"for (i; (i > 0); next(i)) i",
+		},
+		{
+			"for (;;) { }",
+			"", "", "",
+			"for (; ; ) ",
+		},
+		{
+			"for (; ok;) { x }",
+			"", "ok", "",
+			"for (; ok; ) x",
+		},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("%q: program.Statements does not contain 1 statements. got=%d",
+				tt.input, len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ForStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ForStatement. got=%T",
+				program.Statements[0])
+		}
+		clauses := []struct {
+			name     string
+			node     ast.Node
+			expected string
+		}{
+			{"init", stmt.Init, tt.init},
+			{"condition", stmt.Condition, tt.condition},
+			{"post", stmt.Post, tt.post},
+		}
+		for _, c := range clauses {
+			got := ""
+			if c.node != nil {
+				got = c.node.String()
+			}
+			if got != c.expected {
+				t.Errorf("%q: %s wrong. expected=%q, got=%q", tt.input, c.name, c.expected, got)
+			}
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("%q: stmt.String() wrong. expected=%q, got=%q", tt.input, tt.expected, stmt.String())
+		}
+	}
+}
+
+func TestForStatementErrors(t *testing.T) {
+	tests := []string{
+		"for i < 10 { i }",
+		"for (i < 10) { i }",
+		"for (let i = 0; i < 10; i) i",
+	}
+
+	for _, input := range tests {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected parser errors", input)
+		}
+	}
+}
+
+func TestForInStatement(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedBinding  string
+		expectedIterable string
+		expected         string
+	}{
+		{"for (x in xs) { puts(x); }", "x", "xs", "for (x in xs) puts(x)"},
+		{"for (k in keys(h)) { k }", "k", "keys(h)", "for (k in keys(h)) k"},
+		{`for (v in {"a": 1}) { v * 2 }`, "v", "{a:1}", "for (v in {a:1}) (v * 2)"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ForInStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ForInStatement. got=%T",
+				program.Statements[0])
+		}
+		if !testIdentifier(t, stmt.Binding, tt.expectedBinding) {
+			return
+		}
+		if stmt.Iterable.String() != tt.expectedIterable {
+			t.Errorf("%q: iterable wrong. expected=%q, got=%q", tt.input, tt.expectedIterable, stmt.Iterable.String())
+		}
+		if stmt.String() != tt.expected {
+			t.Errorf("%q: stmt.String() wrong. expected=%q, got=%q", tt.input, tt.expected, stmt.String())
+		}
+	}
+}
+
+func TestImportStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		path     string
+		alias    string // empty when absent
+		expected string
+	}{
+		{`import "path/to/module" as m;`, "path/to/module", "m", `import "path/to/module" as m;`},
+		{`import "strings"`, "strings", "", `import "strings";`},
+		{`import "a\tb" as as;`, "a\tb", "as", `import "a\tb" as as;`},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ImportStatement)
+		if !ok {
+			t.Fatalf("stmt is not ast.ImportStatement. got=%T", program.Statements[0])
+		}
+		if stmt.Path.Value != tt.path {
+			t.Errorf("stmt.Path.Value not %q. got=%q", tt.path, stmt.Path.Value)
+		}
+		if tt.alias == "" {
+			if stmt.Alias != nil {
+				t.Errorf("expected no alias, got %s", stmt.Alias)
+			}
+		} else {
+			testIdentifier(t, stmt.Alias, tt.alias)
+		}
+		if got := program.String(); got != tt.expected {
+			t.Errorf("program.String() wrong. want=%q, got=%q", tt.expected, got)
+		}
+	}
+
+	for _, input := range []string{"import m;", `import "m" as;`, `import "m" as "n";`} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected errors", input)
+		}
+	}
+}
+
+func TestCompoundAssign(t *testing.T) {
+	tests := []struct {
+		input    string
+		target   string
+		operator string
+		value    interface{}
+	}{
+		{"x += 5;", "x", "+=", 5},
+		{"x -= y;", "x", "-=", "y"},
+		{"total *= 2;", "total", "*=", 2},
+		{"n /= d;", "n", "/=", "d"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.CompoundAssign)
+		if !ok {
+			t.Fatalf("exp is not ast.CompoundAssign. got=%T", stmt.Expression)
+		}
+		if !testIdentifier(t, exp.Target, tt.target) {
+			return
+		}
+		if exp.Operator != tt.operator {
+			t.Errorf("exp.Operator is not %q. got=%q", tt.operator, exp.Operator)
+		}
+		if !testLiteralExpression(t, exp.Value, tt.value) {
+			return
+		}
+	}
+
+	p := New(lexer.New("5 += 1;"))
+	p.ParseProgram()
+	if len(p.Errors()) != 1 || p.Errors()[0] != "1:3: cannot assign with += to a non-identifier" {
+		t.Errorf("wrong errors for invalid target. got=%q", p.Errors())
+	}
+}
+
+func TestTernaryExpression(t *testing.T) {
+	p := New(lexer.New("x < y ? x : y;"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.TernaryExpression)
+	if !ok {
+		t.Fatalf("exp is not ast.TernaryExpression. got=%T", stmt.Expression)
+	}
+	if !testInfixExpression(t, exp.Condition, "x", "<", "y") {
+		return
+	}
+	testIdentifier(t, exp.Consequence, "x")
+	testIdentifier(t, exp.Alternative, "y")
+
+	p = New(lexer.New("a ? b;"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected an error for a missing ':'")
+	}
+}
+
+func TestRangeExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		start interface{}
+		end   interface{}
+		step  interface{} // nil when absent
+	}{
+		{"1..10;", 1, 10, nil},
+		{"a..b..2;", "a", "b", 2},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.RangeExpression)
+		if !ok {
+			t.Fatalf("exp is not ast.RangeExpression. got=%T", stmt.Expression)
+		}
+		if !testLiteralExpression(t, exp.Start, tt.start) || !testLiteralExpression(t, exp.Stop, tt.end) {
+			return
+		}
+		if tt.step == nil {
+			if exp.Step != nil {
+				t.Errorf("%q: expected no step, got %s", tt.input, exp.Step)
+			}
+		} else if !testLiteralExpression(t, exp.Step, tt.step) {
+			return
+		}
+	}
+}
+
+func TestLexerErrorsReported(t *testing.T) {
+	p := New(lexer.New(`let s = "bad \q escape"; let t = "ok\n";`))
+	program := p.ParseProgram()
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+	errors := p.Errors()
+	if len(errors) != 1 || errors[0] != `1:14: invalid escape sequence "\\q" in string` {
+		t.Errorf("wrong errors. got=%q", errors)
+	}
+}
+
+func TestLineComments(t *testing.T) {
+	input := `let add = fn(a, b) { // adds two numbers
+	a + // left
+	b   // right
+};
+add(1, 2) // no trailing newline`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if got := program.String(); got != "let add = fn(a,b)(a + b);add(1,2)" {
+		t.Errorf("program wrong. got=%q", got)
+	}
+}
+
+func TestBlockComments(t *testing.T) {
+	input := "let x = 1 /* one */ + /* nested /* two */ */ 2; // done"
+
+	for _, l := range []*lexer.Lexer{lexer.New(input), lexer.New(input, lexer.WithComments())} {
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if got := program.String(); got != "let x = (1 + 2);" {
+			t.Errorf("program wrong. got=%q", got)
+		}
+	}
+
+	p := New(lexer.New("let x = 1; /* unterminated"))
+	p.ParseProgram()
+	if len(p.Errors()) != 1 || p.Errors()[0] != "1:12: unterminated block comment" {
+		t.Errorf("wrong errors. got=%q", p.Errors())
+	}
+}
+
+func TestNewlineTermination(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let x = y\n(f || g)(x)", []string{"let x = y;", "(f || g)(x)"}},
+		{"let a = b\n-c", []string{"let a = b;", "(-c)"}},
+		{"let a = b -\n c", []string{"let a = (b - c);"}},
+		{"let r = xs\n  |> map(f)\n  |> sum", []string{"let r = ((xs |> map(f)) |> sum);"}},
+		{"let v = a\n  * b", []string{"let v = (a * b);"}},
+		{"let s = a /* x\n */ + b", []string{"let s = a;", "(+b)"}},
+		{"let t = a // x\n(b)", []string{"let t = a;", "b"}},
+		{"let u = a; (b)", []string{"let u = a;", "b"}},
+		{"f(\n a,\n b\n)", []string{"f(a,b)"}},
+		{"f(a\n-1)", []string{"f((a - 1))"}},
+		{"xs[i\n-1]", []string{"(xs[(i - 1)])"}},
+		{"let w = (a\n+ b)", []string{"let w = (a + b);"}},
+		{"g(fn() { let x = y\n(h)(x) })", []string{"g(fn()let x = y;h(x))"}},
+	}
+
+	for _, tt := range tests {
+		for _, l := range []*lexer.Lexer{lexer.New(tt.input), lexer.New(tt.input, lexer.WithComments())} {
+			p := New(l)
+			program := p.ParseProgram()
+			checkParserErrors(t, p)
+
+			if len(program.Statements) != len(tt.expected) {
+				t.Fatalf("%q: wrong number of statements. want=%d, got=%d", tt.input, len(tt.expected), len(program.Statements))
+			}
+			for i, want := range tt.expected {
+				if got := program.Statements[i].String(); got != want {
+					t.Errorf("%q: statement %d wrong. want=%q, got=%q", tt.input, i, want, got)
+				}
+			}
+		}
+	}
+}
+
+// malformed holds inputs that end or break off inside a construct, where a
+// parse function gets nil for an operand it needs.
+var malformed = []string{
+	"(5 + )",
+	"if (x",
+	"-",
+	"!(",
+	"let x = ;",
+	"return 1 +",
+	"f(1, )",
+	"xs[1 +]",
+	"xs[:*]",
+	"{1: }",
+	"P{x: }",
+	"a ? : b",
+	"a ? b :",
+	"x += ;",
+	"1..",
+	"x |>",
+	"\"a ${} b\"",
+	"quote()",
+	"while (",
+	"do {} while ()",
+	"for (let i = ; i < 1; i += 1) {}",
+	"for (x in ) {}",
+	"assert ;",
+	"fn f() { yield }",
+	"let a, b = 1,",
+	"(1, +)",
+}
+
+func TestMalformedInput(t *testing.T) {
+	for _, input := range malformed {
+		p := New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected parse errors, got none", input)
+		}
+		if program == nil {
+			t.Fatalf("%q: ParseProgram returned nil", input)
+		}
+		_ = program.String() // a nil operand inside a node would panic here
+	}
+}
+
+func TestBailoutReturnsProgram(t *testing.T) {
+	p := New(lexer.New("let x = 1; " + strings.Repeat("(", 5000)))
+	program := p.ParseProgram()
+	if program == nil || len(program.Statements) != 1 {
+		t.Fatalf("expected the statement before the bailout, got %v", program)
+	}
+}
+
+func FuzzParseProgram(f *testing.F) {
+	seeds := []string{
+		"let x = 5; let y = x * (2 + 3);",
+		"fn add(a, b) { return a + b; } add(1, 2);",
+		"if (x < 1) { a } else if (y) { b } else { c }",
+		"let t = (1, \"a\"); t.0; xs[1:2]; xs[-1];",
+		"class A { fn operator +(o) { 1 } fn m() { this } }",
+		"struct P { x, y } let p = P{x: 1, y: 2}; p.x;",
+		"let m = macro(a) { quote(unquote(a)) };",
+		"xs |> map(f) |> sum; 1..10..2; a ? b : c; x += 1;",
+		"for (let i = 0; i < 3; i += 1) {} for (x in xs) { print(x) }",
+		"do { i += 1 } while (i < 3); while (true) {}",
+		"{\"a\": 1}; \"s ${x + 1} t\"; /a+b/i; <<EOF\nx\nEOF\n",
+		"import \"m\" as n; assert x, \"msg\";",
+	}
+	for _, s := range append(seeds, malformed...) {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		p := New(lexer.New(input))
+		program := p.ParseProgram()
+		if program == nil {
+			t.Fatalf("ParseProgram returned nil")
+		}
+		_ = program.String()
+		_, _ = program.Pos(), program.End()
+		if len(p.Errors()) != 0 {
+			return
+		}
+		// whatever parses cleanly prints as source that parses back the same
+		src := ast.Format(program)
+		p = New(lexer.New(src))
+		again := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("%q formatted as %q, which does not parse: %q", input, src, p.Errors())
+		}
+		if !ast.Equal(again, program) {
+			t.Fatalf("%q formatted as %q, which parses as %q", input, src, again.String())
+		}
+	})
+}
+
+func TestCustomOperators(t *testing.T) {
+	const (
+		MODMOD = token.CUSTOM + iota
+		DIAMOND
+		ROOT
+	)
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a %% b * c", "((a %% b) * c)"},
+		{"a + b %% c", "(a + (b %% c))"},
+		{"a %% b %% c", "((a %% b) %% c)"},
+		{"a <> b + 1", "(a <> (b + 1))"},
+		{"a <> b == c", "((a <> b) == c)"},
+		{"√x + 1", "((√x) + 1)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input,
+			lexer.WithOperator("%%", MODMOD),
+			lexer.WithOperator("<>", DIAMOND),
+			lexer.WithOperator("√", ROOT))
+		p := New(l)
+		p.RegisterInfix(MODMOD, PRODUCT)
+		p.RegisterInfix(DIAMOND, EQUALS)
+		p.RegisterPrefix(ROOT)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: wrong output. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	// operators are registered per parser
+	p := New(lexer.New("a %% b", lexer.WithOperator("%%", MODMOD)))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected %%%% to be unknown to a parser it was not registered with")
+	}
+}
+
+func TestWithTracing(t *testing.T) {
+	var out strings.Builder
+	p := New(lexer.New("1;"), WithTracing(&out))
+	p.ParseProgram()
+	checkParserErrors(t, p)
+
+	expected := `BEGIN parseExpressionStatement (INT "1")
+	BEGIN parseExpression (INT "1")
+		BEGIN parseIntegerLiteral (INT "1")
+		END parseIntegerLiteral (INT "1")
+	END parseExpression (INT "1")
+END parseExpressionStatement (; ";")
+`
+	if out.String() != expected {
+		t.Errorf("wrong trace.\nwant:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestWithMaxDepth(t *testing.T) {
+	input := strings.Repeat("(", 10) + "1" + strings.Repeat(")", 10)
+
+	// each parenthesis nests one level, and so does the 1 inside them
+	p := New(lexer.New(input), WithMaxDepth(11))
+	p.ParseProgram()
+	checkParserErrors(t, p)
+
+	p = New(lexer.New(input), WithMaxDepth(10))
+	p.ParseProgram()
+	if errs := p.Errors(); len(errs) != 1 || !strings.Contains(errs[0], "maximum nesting depth of 10 exceeded") {
+		t.Errorf("wrong errors. got=%q", errs)
+	}
+}
+
+func TestStrictSemicolons(t *testing.T) {
+	tests := []struct {
+		input  string
+		errors []string
+	}{
+		{"let x = 1; x + 1; return x;", nil},
+		{"if (x) { y; } while (x) { y; } fn f() { 1; } for (i in xs) {}", nil},
+		{"struct P { x } class A { fn m() { 1; } } do { x; } while (x);", nil},
+		{"let x = 1\nx", []string{"2:1: Expected token ; -- Got IDENT", "2:2: Expected token ; -- Got EOF"}},
+		{"fn f() { return 1 }", []string{"1:19: Expected token ; -- Got }"}},
+		{"do { x; } while (x)", []string{"1:20: Expected token ; -- Got EOF"}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input), WithStrictSemicolons())
+		p.ParseProgram()
+
+		errs := p.Errors()
+		if len(errs) != len(tt.errors) {
+			t.Errorf("%q: wrong errors. want=%q, got=%q", tt.input, tt.errors, errs)
+			continue
+		}
+		for i, want := range tt.errors {
+			if errs[i] != want {
+				t.Errorf("%q: error %d wrong. want=%q, got=%q", tt.input, i, want, errs[i])
+			}
+		}
+	}
+
+	// without the option a newline is enough
+	p := New(lexer.New("let x = 1\nx"))
+	p.ParseProgram()
+	checkParserErrors(t, p)
+}
+
+func TestCommentPreservation(t *testing.T) {
+	input := "// add\nlet x = 1 + /* two */ 2;\nx // done"
+
+	p := New(lexer.New(input), WithCommentPreservation())
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	expected := []string{"// add", "/* two */", "// done"}
+	if len(program.Comments) != len(expected) {
+		t.Fatalf("wrong number of comments. want=%d, got=%d", len(expected), len(program.Comments))
+	}
+	for i, want := range expected {
+		if got := program.Comments[i].Literal; got != want {
+			t.Errorf("comment %d wrong. want=%q, got=%q", i, want, got)
+		}
+	}
+	if got := program.String(); got != "let x = (1 + 2);x" {
+		t.Errorf("wrong program. got=%q", got)
+	}
+
+	program = New(lexer.New(input)).ParseProgram()
+	if len(program.Comments) != 0 {
+		t.Errorf("comments kept without the option. got=%d", len(program.Comments))
+	}
+}
+
+func TestParseExpressionString(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2 * 3", "(1 + (2 * 3))"},
+		{"add(x, 1);", "add(x,1)"},
+		{"{\"port\": 80}", "{port:80}"},
+		{"xs\n  |> sum", "(xs |> sum)"},
+	}
+	for _, tt := range tests {
+		exp, err := ParseExpressionString(tt.input)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.input, err)
+			continue
+		}
+		if got := exp.String(); got != tt.expected {
+			t.Errorf("%q: wrong expression. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	errors := []struct {
+		input string
+		err   string
+	}{
+		{"", "1:1: no prefix parse function for EOF found"},
+		{"1 +", "1:4: no prefix parse function for EOF found"},
+		{"1 2", "1:3: Expected token EOF -- Got INT"},
+		{"let x = 1", "1:1: no prefix parse function for LET found"},
+		{"a |> f", `1:3: feature pipes not enabled at "|>" -- requires version 2, have 1`},
+	}
+	for _, tt := range errors {
+		exp, err := ParseExpressionString(tt.input, WithVersion(1))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: wrong error. want=%q, got=%v", tt.input, tt.err, err)
+		}
+		if exp != nil {
+			t.Errorf("%q: expected no expression, got %q", tt.input, exp)
+		}
+	}
+}
+
+func TestNewFromReader(t *testing.T) {
+	input := strings.Repeat("let add = fn(a, b) { a + b };\nadd(1, <<EOF\n  text\nEOF\n) |> print;\n", 5000)
+
+	p := NewFromReader(iotest.OneByteReader(strings.NewReader(input)))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	expected := New(lexer.New(input)).ParseProgram()
+	if len(program.Statements) != 10000 || program.String() != expected.String() {
+		t.Errorf("program read from a reader differs from the one parsed from a string")
+	}
+}
+
+func TestIncompleteDetection(t *testing.T) {
+	tests := []struct {
+		input      string
+		incomplete string // the error reported, "" for input that is complete
+	}{
+		{"let add = fn(a, b) {", "1:21: input ends before { is closed"},
+		{"if (x) {\n  let y = xs[1 +\n", "3:1: input ends before [ is closed"},
+		{"f(1, g(2", "1:9: input ends before ( is closed"},
+		{"let h = {\"a\": 1,", "1:17: input ends before { is closed"},
+		{"class A { fn m() { 1 }", "1:23: input ends before { is closed"},
+		{"let x = (1 + ;", ""},
+		{"f(1))", ""},
+		{"let x = ", ""},
+		{"let x = 1;", ""},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input), WithIncompleteDetection())
+		p.ParseProgram()
+		errs := p.ParseErrors()
+
+		if tt.incomplete == "" {
+			for _, err := range errs {
+				if errors.Is(err, ErrIncomplete) {
+					t.Errorf("%q: reported as incomplete: %v", tt.input, err)
+				}
+			}
+			continue
+		}
+		if len(errs) != 1 || !errors.Is(errs[0], ErrIncomplete) || errs[0].Error() != tt.incomplete {
+			t.Errorf("%q: wrong errors. want=%q, got=%q", tt.input, tt.incomplete, p.Errors())
+		}
+	}
+
+	// an error before the end is still reported
+	p := New(lexer.New("let = 1; f("), WithIncompleteDetection())
+	p.ParseProgram()
+	if errs := p.ParseErrors(); len(errs) < 2 || errors.Is(errs[0], ErrIncomplete) || !errors.Is(errs[len(errs)-1], ErrIncomplete) {
+		t.Errorf("wrong errors. got=%q", p.Errors())
+	}
+
+	// without the option the errors are reported as usual
+	p = New(lexer.New("f("))
+	p.ParseProgram()
+	if errs := p.ParseErrors(); len(errs) != 1 || errors.Is(errs[0], ErrIncomplete) {
+		t.Errorf("wrong errors. got=%q", p.Errors())
+	}
+}
+
+func TestTracingCoversParseFunctions(t *testing.T) {
+	input := `let f = fn(a, b...) { while (a < 3) { a += 1 } return a ? b : (a, b) };
+f(x: 1)[0:2] |> g; do { yield "${x}" } while (y); for (i in 1..3) {}`
+	seen := map[string]bool{}
+	depth := 0
+	tracer := func(e TraceEvent) {
+		if e.Enter {
+			depth++
+			seen[e.Func] = true
+		} else {
+			depth--
+		}
+		if depth != e.Depth && depth != e.Depth-1 {
+			t.Fatalf("depth of %s is %d, expected %d", e, e.Depth, depth)
+		}
+	}
+
+	p := New(lexer.New(input), WithTracer(tracer))
+	p.ParseProgram()
+
+	if depth != 0 {
+		t.Errorf("unbalanced trace events, depth %d", depth)
+	}
+	for _, fn := range []string{
+		"parseFunctionLiteral", "parseFunctionParameters", "parseBlockStatement",
+		"parseWhileStatement", "parseCompoundAssign", "parseTernaryExpression",
+		"parseGroupedExpression", "parseCallExpression", "parseCallArgument",
+		"parseIndexExpression", "parsePipeExpression", "parseDoWhileStatement",
+		"parseYieldStatement", "parseInterpolatedString", "parseForStatement",
+		"parseForInStatement", "parseRangeExpression", "parseIdentifier",
+	} {
+		if !seen[fn] {
+			t.Errorf("%s not traced", fn)
+		}
+	}
+}
+
+func TestModes(t *testing.T) {
+	tests := []struct {
+		input      string
+		byDefault  []string
+		strict     []string
+		permissive []string
+	}{
+		{
+			"let x = 1\nprint(x)",
+			nil,
+			[]string{"2:1: Expected token ; -- Got IDENT", "2:9: Expected token ; -- Got EOF"},
+			nil,
+		},
+		{
+			"let x = 1; x + 1; print(x); xs |> f; x += 1; if (x) { x; };",
+			nil,
+			[]string{"1:12: (x + 1) is evaluated but not used"},
+			nil,
+		},
+		{
+			"fn f(x) { x + 1; }",
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"f(1,",
+			[]string{"1:5: no prefix parse function for EOF found"},
+			[]string{"1:5: no prefix parse function for EOF found"},
+			[]string{"1:5: input ends before ( is closed"},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, mode := range []struct {
+			mode     Mode
+			expected []string
+		}{
+			{ModeDefault, tt.byDefault},
+			{ModeStrict, tt.strict},
+			{ModePermissive, tt.permissive},
+		} {
+			p := New(lexer.New(tt.input), WithMode(mode.mode))
+			p.ParseProgram()
+			errs := p.Errors()
+			if len(errs) != len(mode.expected) {
+				t.Errorf("%q in mode %d: wrong errors. want=%q, got=%q", tt.input, mode.mode, mode.expected, errs)
+				continue
+			}
+			for i := range errs {
+				if errs[i] != mode.expected[i] {
+					t.Errorf("%q in mode %d: error %d wrong. want=%q, got=%q", tt.input, mode.mode, i, mode.expected[i], errs[i])
+				}
+			}
+		}
+	}
+
+	// a mode adds to the other options whichever comes first
+	for _, opts := range [][]Option{
+		{WithStrictSemicolons(), WithMode(ModePermissive)},
+		{WithMode(ModePermissive), WithStrictSemicolons()},
+	} {
+		p := New(lexer.New("let x = 1\nf(1,"), opts...)
+		p.ParseProgram()
+		want := []string{"2:1: Expected token ; -- Got IDENT", "2:5: input ends before ( is closed"}
+		if !slices.Equal(p.Errors(), want) {
+			t.Errorf("wrong errors. want=%q, got=%q", want, p.Errors())
+		}
+	}
+}
+
+func TestCommentTrivia(t *testing.T) {
+	input := `// Package doc
+// second line
+let x = 1; // one
+let y = /* inline */ 2;
+/* add
+   numbers */
+fn add(a, b) {
+	// the sum
+	return a + b // done
+} // end add
+class A {
+	// m is a method
+	fn m() { 1 }
+}
+x // last`
+
+	p := New(lexer.New(input), WithCommentPreservation())
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	literals := func(toks []token.Token) []string {
+		var out []string
+		for _, tok := range toks {
+			out = append(out, tok.Literal)
+		}
+		return out
+	}
+	check := func(name string, node ast.Node, leading, trailing []string) {
+		t.Helper()
+		trivia := node.(ast.Commented).Comments()
+		if got := literals(trivia.Leading); fmt.Sprint(got) != fmt.Sprint(leading) {
+			t.Errorf("%s: leading comments wrong. want=%q, got=%q", name, leading, got)
+		}
+		if got := literals(trivia.Trailing); fmt.Sprint(got) != fmt.Sprint(trailing) {
+			t.Errorf("%s: trailing comments wrong. want=%q, got=%q", name, trailing, got)
+		}
+	}
+
+	if len(program.Statements) != 5 {
+		t.Fatalf("wrong number of statements. got=%d", len(program.Statements))
+	}
+	check("let x", program.Statements[0], []string{"// Package doc", "// second line"}, []string{"// one"})
+	check("let y", program.Statements[1], nil, nil)
+	add := program.Statements[2].(*ast.FunctionStatement)
+	check("fn add", add, []string{"/* add\n   numbers */"}, []string{"// end add"})
+	check("return", add.Function.Body.Statements[0], []string{"// the sum"}, []string{"// done"})
+	class := program.Statements[3].(*ast.ClassStatement)
+	check("class", class, nil, nil)
+	check("method", class.Methods[0], []string{"// m is a method"}, nil)
+	check("x", program.Statements[4], nil, []string{"// last"})
+
+	if len(program.Comments) != 10 {
+		t.Errorf("wrong number of comments. want=10, got=%d", len(program.Comments))
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	tests := []struct {
+		input string
+		opts  []Option
+		code  Code
+		str   string
+	}{
+		{"let = 1;", nil, CodeUnexpectedToken, "E001"},
+		{";", nil, CodeNoPrefixParseFn, "E002"},
+		{"99999999999999999999;", nil, CodeBadInteger, "E003"},
+		{"a |> f;", []Option{WithVersion(Version1)}, CodeFeatureDisabled, "E004"},
+		{"((1));", []Option{WithMaxDepth(2)}, CodeMaxDepth, "E005"},
+		{"1 += 2;", nil, CodeInvalidAssignTarget, "E006"},
+		{`"\q";`, nil, CodeLexer, "E007"},
+		{"yield 1;", nil, CodeYieldOutsideFunction, "E008"},
+		{"class A { fn operator ?(o) { 1 } }", nil, CodeNotOverloadable, "E009"},
+		{"1 < 2 < 3;", nil, CodeChainedComparison, "E010"},
+		{"f(1,", []Option{WithIncompleteDetection()}, CodeIncomplete, "E011"},
+		{"x + 1;", []Option{WithMode(ModeStrict)}, CodeUnusedExpression, "E012"},
+		{"macro(a...) { a };", nil, CodeVariadicMacro, "E013"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input), tt.opts...)
+		p.ParseProgram()
+		errs := p.ParseErrors()
+		if len(errs) == 0 {
+			t.Errorf("%q: expected an error, got none", tt.input)
+			continue
+		}
+		if errs[0].Code != tt.code || errs[0].Code.String() != tt.str {
+			t.Errorf("%q: wrong code. want=%s, got=%s (%v)", tt.input, tt.str, errs[0].Code, errs[0])
+		}
+	}
+}
+
+func TestWithDiagnostics(t *testing.T) {
+	var out strings.Builder
+	p := New(lexer.New("let x = 1;\nif (x) {}\nlet = 2;\nwhile (x) {}"), WithDiagnostics(&out))
+	p.ParseProgram()
+
+	expected := `2:8: warning: empty if body
+3:5: error[E001]: Expected token IDENT -- Got =
+3:5: error[E002]: no prefix parse function for = found
+4:11: warning: empty while body
+`
+	if out.String() != expected {
+		t.Errorf("wrong diagnostics.\nwant:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestErr(t *testing.T) {
+	p := New(lexer.New("let x = 1;"))
+	p.ParseProgram()
+	if err := p.Err(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	p = New(lexer.New("let = 1;\nlet y 2;"))
+	p.ParseProgram()
+	err := p.Err()
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if got, want := err.Error(), strings.Join(p.Errors(), "\n"); got != want {
+		t.Errorf("wrong message. want=%q, got=%q", want, got)
+	}
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Code != CodeUnexpectedToken || parseErr.Pos.Line != 1 {
+		t.Errorf("errors.As did not find the first error, got %v", parseErr)
+	}
+	if errors.Is(err, ErrIncomplete) {
+		t.Errorf("complete input reported as incomplete")
+	}
+
+	p = New(lexer.New("let = 1; f("), WithIncompleteDetection())
+	p.ParseProgram()
+	if err := p.Err(); !errors.Is(err, ErrIncomplete) {
+		t.Errorf("expected ErrIncomplete in %v", err)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"let x=1+2*3", "let x = 1 + 2 * 3;\n"},
+		{"let x = (1 + 2) * 3;", "let x = (1 + 2) * 3;\n"},
+		{"(((a)))", "a;\n"},
+		{"a - (b - c); (a - b) - c", "a - (b - c);\na - b - c;\n"},
+		{"(2 ** 3) ** 2; 2 ** (3 ** 2)", "(2 ** 3) ** 2;\n2 ** 3 ** 2;\n"},
+		{"(a < b) == (c < d); (a == b) == c", "a < b == c < d;\n(a == b) == c;\n"},
+		{"-(a + b); -a.b; (-a) ** 2", "-(a + b);\n-a.b;\n-a ** 2;\n"},
+		{"(f || g)(x); (a + b).c; (a ? b : c) ? d : e", "(f || g)(x);\n(a + b).c;\n(a ? b : c) ? d : e;\n"},
+		{"x += (y > 0 ? 1 : 2)", "x += y > 0 ? 1 : 2;\n"},
+		{"xs |> map(f) |> sum", "xs |> map(f) |> sum;\n"},
+		{"let r = (1 .. n .. 2)", "let r = 1..n..2;\n"},
+		{"return a,b", "return a, b;\n"},
+		{"let t = (a,b); let u = (a,);", "let t = (a, b);\nlet u = (a,);\n"},
+		{`let s = "a\"b\n${x}\${y}"; let r = ` + "`raw\\n`", `let s = "a\"b\n${x}\${y}";` + "\nlet r = `raw\\n`;\n"},
+		{`{"a":1,"b":xs[1:]}`, `{"a": 1, "b": xs[1:]};` + "\n"},
+		{"let p = Point{x:1,y:t.0}", "let p = Point{x: 1, y: t.0};\n"},
+		{"draw(1, color: red)", "draw(1, color: red);\n"},
+		{"if (x) { 1 } else if (y) { 2 } else { 3 }",
+			"if (x) {\n\t1;\n} else if (y) {\n\t2;\n} else {\n\t3;\n}\n"},
+		{"let f = fn(a, rest...) { return a }; f(1)",
+			"let f = fn(a, rest...) {\n\treturn a;\n};\nf(1);\n"},
+		{"let x = 1\nfn add(a,b){a+b}\nstruct P {x,y}\nclass C { fn m() {} fn operator +(o) { o } }",
+			"let x = 1;\n\nfn add(a, b) {\n\ta + b;\n}\n\nstruct P { x, y }\n\nclass C {\n\tfn m() {}\n\n\tfn operator +(o) {\n\t\to;\n\t}\n}\n"},
+		{"while (i < 3) { i += 1 } do { i -= 1 } while (i > 0); for (let i = 0; i < 3; i += 1) {} for (;;) {} for (x in xs) { print(x) }",
+			"while (i < 3) {\n\ti += 1;\n}\ndo {\n\ti -= 1;\n} while (i > 0);\nfor (let i = 0; i < 3; i += 1) {}\nfor (;;) {}\nfor (x in xs) {\n\tprint(x);\n}\n"},
+		{`import "math" as m; assert x > 0, "positive"`, "import \"math\" as m;\nassert x > 0, \"positive\";\n"},
+		{"let m = macro(a, b) { quote(unquote(a) + b) }", "let m = macro(a, b) {\n\tquote(unquote(a) + b);\n};\n"},
+		{"let longName = someFunction(firstArgument, secondArgument, thirdArgument, fourth)",
+			"let longName = someFunction(\n\tfirstArgument,\n\tsecondArgument,\n\tthirdArgument,\n\tfourth\n);\n"},
+		{`let config = {"name": "formatter", "width": 80, "tabs": true, "comments": "kept"}`,
+			"let config = {\n\t\"name\": \"formatter\",\n\t\"width\": 80,\n\t\"tabs\": true,\n\t\"comments\": \"kept\",\n};\n"},
+		{"xs.each(fn(x) { print(x) })", "xs.each(fn(x) {\n\tprint(x);\n});\n"},
+		{"s.trim().len(); f(x)[0]; a.b(c).d", "s.trim().len();\nf(x)[0];\na.b(c).d;\n"},
+		{"xs[1](y).z[2:]; t.0(a)[b]; (-a).b(c)", "xs[1](y).z[2:];\nt.0(a)[b];\n(-a).b(c);\n"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		got := ast.Format(program)
+		if got != tt.want {
+			t.Errorf("Format(%q)\ngot:\n%s\nwant:\n%s", tt.input, got, tt.want)
+			continue
+		}
+		// the output parses back to the same program
+		p = New(lexer.New(got))
+		again := p.ParseProgram()
+		checkParserErrors(t, p)
+		if !ast.Equal(again, program) {
+			t.Errorf("Format(%q) reparsed as %q, want %q", tt.input, again.String(), program.String())
+		}
+		if twice := ast.Format(again); twice != got {
+			t.Errorf("Format(%q) reformatted as %q, want %q", tt.input, twice, got)
+		}
+	}
+}
+
+func TestFormatComments(t *testing.T) {
+	input := `// header
+let x = 1; // one
+fn f() {
+	/* inside */
+	return x
+}`
+	want := `// header
+let x = 1; // one
+
+fn f() {
+	/* inside */
+	return x;
+}
+`
+	p := New(lexer.New(input), WithCommentPreservation())
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if got := ast.Format(program); got != want {
+		t.Errorf("Format got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSpan(t *testing.T) {
+	input := "let x = 1;\nlet y = add(x,\n\t2) * 3;"
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	value := program.Statements[1].(*ast.LetStatement).Value
+	start, end := ast.Span(input, value)
+	if start.String() != "2:9" || end.String() != "3:8" {
+		t.Errorf("Span = %s-%s, want 2:9-3:8", start, end)
+	}
+	if got := input[start.Offset:end.Offset]; got != "add(x,\n\t2) * 3" {
+		t.Errorf("span covers %q", got)
+	}
+}
+
+func TestEqualParsedTrees(t *testing.T) {
+	ident := func(name string) *ast.Identifier { return &ast.Identifier{Value: name} }
+	want := &ast.Program{Statements: []ast.Statement{
+		&ast.LetStatement{
+			Name: ident("x"),
+			Value: &ast.InfixExpression{
+				Left:     &ast.IntegerLiteral{Value: 1},
+				Operator: "+",
+				Right:    &ast.InfixExpression{Left: ident("y"), Operator: "*", Right: &ast.IntegerLiteral{Value: 2}},
+			},
+		},
+	}}
+
+	for _, input := range []string{"let x = 1 + y * 2;", "let   x=1+(y*2)", "let x = (1 + ((y) * 2))"} {
+		p := New(lexer.New(input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if !ast.Equal(program, want) {
+			t.Errorf("%q parsed as %s, want %s", input, program, want)
+		}
+	}
+
+	p := New(lexer.New("let x = (1 + y) * 2;"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if ast.Equal(program, want) {
+		t.Errorf("ast.Equal ignored the grouping of %s", program)
 	}
-	testLiteralExpression(t, exp.Arguments[0], 1)
-	testInfixExpression(t, exp.Arguments[1], 2, "*", 3)
-	testInfixExpression(t, exp.Arguments[2], 4, "+", 5)
 }
@@ -2,31 +2,42 @@ package parser
 
 import (
 	"fmt"
-	"strings"
+	"interpreter/token"
 )
 
-var traceLevel int = 0
-
-const traceIdentPlaceholder string = "\t"
-
-func identLevel() string {
-	return strings.Repeat(traceIdentPlaceholder, traceLevel-1)
+// TraceEvent describes entering or leaving a parse function.
+type TraceEvent struct {
+	Enter bool        // true on entry, false on exit
+	Func  string      // name of the parse function
+	Token token.Token // current token when the event fired
+	Depth int         // trace nesting, 1 for the outermost call
 }
 
-func tracePrint(fs string) {
-	fmt.Printf("%s%s\n", identLevel(), fs)
+func (e TraceEvent) String() string {
+	verb := "END"
+	if e.Enter {
+		verb = "BEGIN"
+	}
+	return fmt.Sprintf("%s %s (%s %q)", verb, e.Func, e.Token.Type, e.Token.Literal)
 }
 
-func incIdent() { traceLevel = traceLevel + 1 }
-func decIdent() { traceLevel = traceLevel - 1 }
+// Tracer receives an event on entry to and exit from each traced parse
+// function. It is only called when set with WithTracer.
+type Tracer func(TraceEvent)
 
-func trace(msg string) string {
-	incIdent()
-	tracePrint("BEGIN " + msg)
+func (p *Parser) trace(msg string) string {
+	if p.tracer == nil {
+		return msg
+	}
+	p.traceLevel++
+	p.tracer(TraceEvent{Enter: true, Func: msg, Token: p.curToken, Depth: p.traceLevel})
 	return msg
 }
 
-func untrace(msg string) {
-	tracePrint("END " + msg)
-	decIdent()
+func (p *Parser) untrace(msg string) {
+	if p.tracer == nil {
+		return
+	}
+	p.tracer(TraceEvent{Func: msg, Token: p.curToken, Depth: p.traceLevel})
+	p.traceLevel--
 }
@@ -0,0 +1,63 @@
+package parser
+
+import (
+	"interpreter/token"
+)
+
+// Version identifies a revision of the Monkey grammar the parser accepts.
+// An older Version holds back the keywords added after it, which are read as
+// identifiers, and the Features added after it, which are reported. The rest
+// of the syntax added since the book, such as new operators and literals and
+// statements ended by a newline, is accepted by every Version.
+type Version int
+
+const (
+	Version1 Version = iota + 1 // the keywords of the book, see keywordVersions
+	Version2                    // every later keyword, pipes, macros
+
+	LatestVersion = Version2
+)
+
+// Feature names a piece of syntax that only exists from a given Version on.
+type Feature string
+
+const (
+	FeaturePipes  Feature = "pipes"
+	FeatureMacros Feature = "macros"
+)
+
+// featureVersions maps each gated feature to the first Version supporting it.
+var featureVersions = map[Feature]Version{
+	FeaturePipes:  Version2,
+	FeatureMacros: Version2,
+}
+
+// requireFeature appends a "feature not enabled" error at t unless f is
+// enabled for the parser's Version.
+func (p *Parser) requireFeature(f Feature, t token.Token) {
+	if since, ok := featureVersions[f]; ok && p.version < since {
+		p.addError(CodeFeatureDisabled, t, f, since, p.version)
+	}
+}
+
+// keywordVersions is indexed by token type and holds the first Version in
+// which a keyword is one. Before it the word is read as an identifier, so
+// scripts that use it as a name keep parsing, and the syntax it starts is
+// out of reach. Keywords without an entry are those of the book.
+var keywordVersions = [256]Version{
+	token.WHILE:  Version2,
+	token.DO:     Version2,
+	token.FOR:    Version2,
+	token.IN:     Version2,
+	token.NULL:   Version2,
+	token.IMPORT: Version2,
+	token.STRUCT: Version2,
+	token.YIELD:  Version2,
+	token.CLASS:  Version2,
+	token.ASSERT: Version2,
+
+	// FeatureMacros
+	token.QUOTE:   Version2,
+	token.UNQUOTE: Version2,
+	token.MACRO:   Version2,
+}
@@ -0,0 +1,90 @@
+package parser
+
+import (
+	"interpreter/ast"
+	"interpreter/lexer"
+	"interpreter/token"
+	"testing"
+)
+
+func TestRequireFeature(t *testing.T) {
+	tok := token.Token{Type: token.IDENT, Literal: "x"}
+	tests := []struct {
+		version Version
+		enabled bool
+	}{
+		{Version1, false},
+		{Version2, true},
+		{LatestVersion, true},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(""), WithVersion(tt.version))
+		p.requireFeature(FeaturePipes, tok)
+		if tt.enabled && len(p.Errors()) != 0 {
+			t.Errorf("version %d: unexpected errors %q", tt.version, p.Errors())
+		}
+		if !tt.enabled && len(p.Errors()) != 1 {
+			t.Errorf("version %d: expected 1 error, got %q", tt.version, p.Errors())
+		}
+	}
+}
+
+func TestDefaultVersionIsLatest(t *testing.T) {
+	p := New(lexer.New(""))
+	if p.version != LatestVersion {
+		t.Errorf("default version = %d, want %d", p.version, LatestVersion)
+	}
+}
+
+func TestPipesRequireVersion2(t *testing.T) {
+	input := "xs |> map(f);"
+
+	p := New(lexer.New(input), WithVersion(Version2))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if _, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.PipeExpression); !ok {
+		t.Fatalf("exp is not ast.PipeExpression. got=%s", program.Statements[0])
+	}
+
+	p = New(lexer.New(input), WithVersion(Version1))
+	p.ParseProgram()
+	want := `1:4: feature pipes not enabled at "|>" -- requires version 2, have 1`
+	if len(p.Errors()) != 1 || p.Errors()[0] != want {
+		t.Errorf("wrong errors. got=%q", p.Errors())
+	}
+}
+
+func TestMacrosRequireVersion2(t *testing.T) {
+	input := "let quote = 1; let macro = fn(unquote) { unquote }; macro(quote);"
+
+	p := New(lexer.New(input), WithVersion(Version1))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if want := "let quote = 1;let macro = fn(unquote)unquote;macro(quote)"; program.String() != want {
+		t.Errorf("program wrong. want=%q, got=%q", want, program.String())
+	}
+
+	p = New(lexer.New(input), WithVersion(Version2))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 || p.Errors()[0] != "1:5: Expected token IDENT -- Got QUOTE" {
+		t.Errorf("wrong errors. got=%q", p.Errors())
+	}
+}
+
+func TestKeywordsRequireVersion2(t *testing.T) {
+	input := "let class = 1; let in = fn(while) { while + class }; in(null);"
+
+	p := New(lexer.New(input), WithVersion(Version1))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if want := "let class = 1;let in = fn(while)(while + class);in(null)"; program.String() != want {
+		t.Errorf("program wrong. want=%q, got=%q", want, program.String())
+	}
+
+	p = New(lexer.New(input), WithVersion(Version2))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 || p.Errors()[0] != "1:5: Expected token IDENT -- Got CLASS" {
+		t.Errorf("wrong errors. got=%q", p.Errors())
+	}
+}
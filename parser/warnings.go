@@ -0,0 +1,70 @@
+package parser
+
+import (
+	"fmt"
+	"interpreter/ast"
+	"interpreter/token"
+	"io"
+	"slices"
+)
+
+// Diagnostic is a warning about code that parses but is probably not what was
+// meant, such as an if with an empty body. Warnings never stop a parse and
+// are kept apart from errors, see Parser.Warnings.
+type Diagnostic struct {
+	Pos token.Position
+	Msg string
+}
+
+// String returns the warning as line:column: message.
+func (d Diagnostic) String() string {
+	return d.Pos.String() + ": " + d.Msg
+}
+
+// Warnings returns the warnings found so far, in input order.
+func (p *Parser) Warnings() []Diagnostic {
+	return p.warnings
+}
+
+func (p *Parser) addWarning(pos token.Position, format string, args ...any) {
+	p.warnings = append(p.warnings, Diagnostic{Pos: pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+// warnEmptyBlock warns about the empty body of a control flow statement;
+// function bodies may be empty on purpose.
+func (p *Parser) warnEmptyBlock(block *ast.BlockStatement, construct string) {
+	if block != nil && len(block.Statements) == 0 {
+		p.addWarning(block.Token.Pos(), "empty %s body", construct)
+	}
+}
+
+// warnAssignCondition warns about a condition that assigns, as in
+// while (n -= 1), which is more often a typo for a comparison.
+func (p *Parser) warnAssignCondition(cond ast.Expression) {
+	if assign, ok := cond.(*ast.CompoundAssign); ok {
+		p.addWarning(assign.Token.Pos(), "assignment %s used as a condition", assign.Operator)
+	}
+}
+
+// writeDiagnostics writes the errors and warnings of a finished parse to the
+// writer set WithDiagnostics, in input order.
+func (p *Parser) writeDiagnostics() {
+	if p.diagnostics == nil {
+		return
+	}
+	type line struct {
+		pos token.Position
+		msg string
+	}
+	lines := make([]line, 0, len(p.errors)+len(p.warnings))
+	for _, err := range p.errors {
+		lines = append(lines, line{err.Pos, fmt.Sprintf("%s: error[%s]: %s\n", err.Pos, err.Code, err.message())})
+	}
+	for _, w := range p.warnings {
+		lines = append(lines, line{w.Pos, fmt.Sprintf("%s: warning: %s\n", w.Pos, w.Msg)})
+	}
+	slices.SortStableFunc(lines, func(a, b line) int { return a.pos.Offset - b.pos.Offset })
+	for _, l := range lines {
+		io.WriteString(p.diagnostics, l.msg)
+	}
+}
@@ -0,0 +1,40 @@
+package token
+
+import "strings"
+
+// Interner deduplicates identifier names so every occurrence of a name shares
+// one string, and numbers them in order of first appearance so later passes
+// can refer to a symbol by index instead of by name.
+type Interner struct {
+	index map[string]int
+	names []string
+}
+
+func NewInterner() *Interner {
+	return &Interner{index: make(map[string]int)}
+}
+
+// Intern returns the canonical copy of name and its index.
+func (in *Interner) Intern(name string) (string, int) {
+	if i, ok := in.index[name]; ok {
+		return in.names[i], i
+	}
+	// clone so the table does not keep the whole source string alive
+	name = strings.Clone(name)
+	i := len(in.names)
+	in.index[name] = i
+	in.names = append(in.names, name)
+	return name, i
+}
+
+// Lookup returns the index of name, if it has been interned.
+func (in *Interner) Lookup(name string) (int, bool) {
+	i, ok := in.index[name]
+	return i, ok
+}
+
+// Name returns the name interned at index i.
+func (in *Interner) Name(i int) string { return in.names[i] }
+
+// Len returns the number of distinct names interned.
+func (in *Interner) Len() int { return len(in.names) }
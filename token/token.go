@@ -1,54 +1,233 @@
 package token
 
-type TokenType string
+import "strconv"
 
-// Token has 2 fields Type(TokenType) and Literal(string).
+// TokenType is a small integer kind so tables keyed by it can be plain arrays.
+// String returns the display name used in error messages.
+type TokenType uint8
+
+// Token has a Type(TokenType) and Literal(string), plus the span of input it
+// was scanned from. For most tokens Literal is a slice of the input, not a
+// copy; a string literal without escapes slices the text between its quotes.
+// It is a separate string for an IDENT, which holds the lexer's interned
+// name, for a string literal with escape sequences, which holds the decoded
+// text, and for a heredoc, which is built from its lines.
 type Token struct {
-	Type    TokenType
-	Literal string
+	Type      TokenType
+	Literal   string
+	Offset    int  // byte offset of the first character
+	End       int  // byte offset just past the last character
+	Line      int  // line of the first character, starting at 1
+	Column    int  // byte column of the first character, starting at 1
+	LineStart bool // a newline separates the token from the one before it
+}
+
+// Pos returns the position of the token's first character.
+func (t Token) Pos() Position {
+	return Position{Offset: t.Offset, Line: t.Line, Column: t.Column}
+}
+
+// Position is a location in the input. Columns count bytes, not characters.
+type Position struct {
+	Offset int // byte offset, starting at 0
+	Line   int // starting at 1
+	Column int // starting at 1
+}
+
+// String returns the position as line:column.
+func (p Position) String() string {
+	return strconv.Itoa(p.Line) + ":" + strconv.Itoa(p.Column)
 }
 
 const (
-	ILLEGAL = "ILLEGAL"
-	EOF     = "EOF"
+	ILLEGAL TokenType = iota
+	EOF
+	COMMENT // only emitted by lexers created WithComments
 
 	// Identifiers + literals
-	IDENT = "IDENT" // add, foobar, x, y, ...
-	INT   = "INT"   // 1343456
+	IDENT  // add, foobar, x, y, ...
+	INT    // 1343456
+	STRING // "foo bar"
+
+	// Interpolated strings: "a ${x} b ${y} c" is STRING_HEAD("a "), the tokens
+	// of x, STRING_MID(" b "), the tokens of y, then STRING_TAIL(" c").
+	STRING_HEAD
+	STRING_MID
+	STRING_TAIL
+
+	RAW_STRING // `foo bar`, kept as written
+	REGEX      // /ab+c/i, see lexer.Lexer.ScanRegex
 
 	// Operators
-	ASSIGN   = "="
-	PLUS     = "+"
-	MINUS    = "-"
-	BANG     = "!"
-	ASTERISK = "*"
-	SLASH    = "/"
+	ASSIGN
+	PLUS
+	MINUS
+	BANG
+	ASTERISK
+	SLASH
+	POWER
 
-	LT = "<"
-	GT = ">"
+	LT
+	GT
 
-	EQ     = "=="
-	NOT_EQ = "!="
+	EQ
+	NOT_EQ
+
+	AND
+	OR
+
+	BIT_AND
+	BIT_OR
+	BIT_XOR
+	BIT_NOT
+	SHL
+	SHR
+
+	PIPE
+
+	DOT
+	DOTDOT
+
+	PLUS_ASSIGN
+	MINUS_ASSIGN
+	ASTERISK_ASSIGN
+	SLASH_ASSIGN
 
 	// Delimiters
-	COMMA     = ","
-	SEMICOLON = ";"
+	COMMA
+	SEMICOLON
+	COLON
+	QUESTION
+	ELLIPSIS
 
-	LPAREN = "("
-	RPAREN = ")"
-	LBRACE = "{"
-	RBRACE = "}"
+	LPAREN
+	RPAREN
+	LBRACE
+	RBRACE
+	LBRACKET
+	RBRACKET
 
 	// Keywords
-	FUNCTION = "FUNCTION"
-	LET      = "LET"
-	TRUE     = "TRUE"
-	FALSE    = "FALSE"
-	IF       = "IF"
-	ELSE     = "ELSE"
-	RETURN   = "RETURN"
+	FUNCTION
+	LET
+	TRUE
+	FALSE
+	IF
+	ELSE
+	RETURN
+	WHILE
+	DO
+	FOR
+	IN
+	NULL
+	IMPORT
+	STRUCT
+	YIELD
+	CLASS
+	ASSERT
+	QUOTE
+	UNQUOTE
+	MACRO
 )
 
+// CUSTOM is the first of the token types left free for operators added by
+// embedders, see lexer.WithOperator. Types from CUSTOM up are never used by
+// the language itself.
+const CUSTOM TokenType = 192
+
+var names = [...]string{
+	ILLEGAL: "ILLEGAL",
+	EOF:     "EOF",
+	COMMENT: "COMMENT",
+
+	IDENT:  "IDENT",
+	INT:    "INT",
+	STRING: "STRING",
+
+	STRING_HEAD: "STRING_HEAD",
+	STRING_MID:  "STRING_MID",
+	STRING_TAIL: "STRING_TAIL",
+
+	RAW_STRING: "RAW_STRING",
+	REGEX:      "REGEX",
+
+	ASSIGN:   "=",
+	PLUS:     "+",
+	MINUS:    "-",
+	BANG:     "!",
+	ASTERISK: "*",
+	SLASH:    "/",
+	POWER:    "**",
+
+	LT: "<",
+	GT: ">",
+
+	EQ:     "==",
+	NOT_EQ: "!=",
+
+	AND: "&&",
+	OR:  "||",
+
+	BIT_AND: "&",
+	BIT_OR:  "|",
+	BIT_XOR: "^",
+	BIT_NOT: "~",
+	SHL:     "<<",
+	SHR:     ">>",
+
+	PIPE: "|>",
+
+	DOT:    ".",
+	DOTDOT: "..",
+
+	PLUS_ASSIGN:     "+=",
+	MINUS_ASSIGN:    "-=",
+	ASTERISK_ASSIGN: "*=",
+	SLASH_ASSIGN:    "/=",
+
+	COMMA:     ",",
+	SEMICOLON: ";",
+	COLON:     ":",
+	QUESTION:  "?",
+	ELLIPSIS:  "...",
+
+	LPAREN: "(",
+	RPAREN: ")",
+	LBRACE: "{",
+	RBRACE: "}",
+
+	LBRACKET: "[",
+	RBRACKET: "]",
+
+	FUNCTION: "FUNCTION",
+	LET:      "LET",
+	TRUE:     "TRUE",
+	FALSE:    "FALSE",
+	IF:       "IF",
+	ELSE:     "ELSE",
+	RETURN:   "RETURN",
+	WHILE:    "WHILE",
+	DO:       "DO",
+	FOR:      "FOR",
+	IN:       "IN",
+	NULL:     "NULL",
+	IMPORT:   "IMPORT",
+	STRUCT:   "STRUCT",
+	YIELD:    "YIELD",
+	CLASS:    "CLASS",
+	ASSERT:   "ASSERT",
+	QUOTE:    "QUOTE",
+	UNQUOTE:  "UNQUOTE",
+	MACRO:    "MACRO",
+}
+
+func (t TokenType) String() string {
+	if int(t) < len(names) && names[t] != "" {
+		return names[t]
+	}
+	return "TokenType(" + strconv.Itoa(int(t)) + ")"
+}
+
 var keywords = map[string]TokenType{
 	"fn":     FUNCTION,
 	"let":    LET,
@@ -57,6 +236,21 @@ var keywords = map[string]TokenType{
 	"if":     IF,
 	"else":   ELSE,
 	"return": RETURN,
+	"while":  WHILE,
+	"do":     DO,
+	"for":    FOR,
+	"in":     IN,
+	"null":   NULL,
+	"import": IMPORT,
+	"struct": STRUCT,
+	"yield":  YIELD,
+	"class":  CLASS,
+	"assert": ASSERT,
+
+	// macro special forms
+	"quote":   QUOTE,
+	"unquote": UNQUOTE,
+	"macro":   MACRO,
 }
 
 func LookupIdent(ident string) TokenType {